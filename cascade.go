@@ -0,0 +1,481 @@
+package dom
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// userAgentDisplays and userAgentWhiteSpaces are the subset of the default
+// browser stylesheet which matters for the properties supported by Cascade,
+// as the values set for each tag name. Besides these, the stylesheet hides
+// elements with `hidden` attribute and doesn't wrap <td> and <th> with
+// `nowrap` attribute, see userAgentStyle.
+var (
+	userAgentDisplays = tagNameTable(map[string]string{
+		"none": "area base basefont datalist head link meta noembed noframes noscript " +
+			"param rp script style template title",
+		"block": "address blockquote center dialog div figure figcaption footer form " +
+			"header hr legend listing main p plaintext pre search xmp article aside " +
+			"h1 h2 h3 h4 h5 h6 hgroup nav section dir dd dl dt menu ol ul fieldset " +
+			"details summary html body optgroup option",
+		"list-item":          "li",
+		"table":              "table",
+		"table-caption":      "caption",
+		"table-column-group": "colgroup",
+		"table-column":       "col",
+		"table-header-group": "thead",
+		"table-row-group":    "tbody",
+		"table-footer-group": "tfoot",
+		"table-row":          "tr",
+		"table-cell":         "td th",
+		"ruby":               "ruby",
+		"ruby-text":          "rt",
+		"inline-block":       "input button select textarea meter progress",
+	})
+
+	userAgentWhiteSpaces = tagNameTable(map[string]string{
+		"pre":      "listing plaintext pre xmp",
+		"pre-wrap": "textarea",
+		"nowrap":   "nobr",
+	})
+)
+
+// cssProperty describes how a property supported by Cascade is computed.
+type cssProperty struct {
+	index     int
+	initial   string
+	inherited bool
+}
+
+// cascadedProperties are the properties that can be computed by Cascade.
+var cascadedProperties = map[string]cssProperty{
+	"display":            {index: displayIndex, initial: "inline"},
+	"visibility":         {index: visibilityIndex, initial: "visible", inherited: true},
+	"content-visibility": {index: contentVisibilityIndex, initial: "visible"},
+	"white-space":        {index: whiteSpaceIndex, initial: "normal", inherited: true},
+}
+
+// computedStyle contains the computed values of cascadedProperties,
+// ordered by their index.
+type computedStyle [4]string
+
+// Indexes of the properties in computedStyle.
+const (
+	displayIndex = iota
+	visibilityIndex
+	contentVisibilityIndex
+	whiteSpaceIndex
+)
+
+// initialStyle is the style of nodes which don't have any parent element.
+var initialStyle = computedStyle{"inline", "visible", "visible", "normal"}
+
+// cssRule is a style rule with a single complex selector.
+type cssRule struct {
+	selector     cascadia.Sel
+	specificity  cascadia.Specificity
+	declarations []CSSDeclaration
+	order        int
+}
+
+// Cascade resolves the style of elements using the rules from the <style>
+// elements of a document, the inline style attributes and the default
+// browser stylesheet. Only a small subset of properties is supported:
+// display, visibility, content-visibility and white-space.
+//
+// Stylesheets from <link> elements are not loaded, and rules inside
+// @media are only used when the media query is unconditional (e.g.
+// `all` or `screen` without any media feature). Cascade is a snapshot
+// of the document when it's created and caches the computed values, so
+// if the document is modified a new Cascade should be created.
+type Cascade struct {
+	mu       sync.Mutex
+	rules    []cssRule
+	computed map[*html.Node]computedStyle
+}
+
+// NewCascade parses the <style> elements inside doc and returns
+// a Cascade to compute the style of its elements.
+func NewCascade(doc *html.Node) *Cascade {
+	c := &Cascade{computed: make(map[*html.Node]computedStyle)}
+	for _, style := range GetElementsByTagName(doc, "style") {
+		if media := GetAttribute(style, "media"); HasAttribute(style, "media") && !matchMediaQuery(media) {
+			continue
+		}
+		c.rules = append(c.rules, parseStyleSheet(TextContent(style), len(c.rules))...)
+	}
+
+	return c
+}
+
+// userAgentStyle returns the values of cascadedProperties set for node
+// by the default browser stylesheet, or empty string if there are none.
+func userAgentStyle(node *html.Node) computedStyle {
+	var style computedStyle
+	style[displayIndex] = userAgentDisplays[node.Data]
+	style[whiteSpaceIndex] = userAgentWhiteSpaces[node.Data]
+
+	if HasAttribute(node, "hidden") {
+		style[displayIndex] = "none"
+	}
+
+	if (node.Data == "td" || node.Data == "th") && HasAttribute(node, "nowrap") {
+		style[whiteSpaceIndex] = "nowrap"
+	}
+
+	return style
+}
+
+// tagNameTable converts the space separated tag names for each value
+// into a map from tag name to the value.
+func tagNameTable(values map[string]string) map[string]string {
+	table := make(map[string]string)
+	for value, tagNames := range values {
+		for _, tagName := range strings.Fields(tagNames) {
+			table[tagName] = value
+		}
+	}
+	return table
+}
+
+// ComputedStyle returns the computed value of the specified property of
+// node, or empty string if the property is not supported by Cascade.
+func (c *Cascade) ComputedStyle(node *html.Node, property string) string {
+	property = strings.ToLower(strings.TrimSpace(property))
+	prop, supported := cascadedProperties[property]
+	if node == nil || !supported {
+		return ""
+	}
+
+	if node.Type != html.ElementNode {
+		if node.Parent == nil || !prop.inherited {
+			return prop.initial
+		}
+		return c.ComputedStyle(node.Parent, property)
+	}
+
+	return c.computedStyle(node)[prop.index]
+}
+
+// computedStyle returns the computed style of element node.
+func (c *Cascade) computedStyle(node *html.Node) computedStyle {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if style, exist := c.computed[node]; exist {
+		return style
+	}
+
+	// Compute the ancestors first, from the top most one
+	var chain []*html.Node
+	for n := node; n != nil && n.Type == html.ElementNode; n = n.Parent {
+		if _, exist := c.computed[n]; exist {
+			break
+		}
+		chain = append(chain, n)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		var parent *computedStyle
+		if style, exist := c.computed[chain[i].Parent]; exist {
+			parent = &style
+		}
+		c.computed[chain[i]] = elementStyle(c.rules, chain[i], parent)
+	}
+
+	return c.computed[node]
+}
+
+// elementStyle resolves all supported properties of element node using
+// the rules, the default browser stylesheet and its inline style. parent
+// is the computed style of its parent element, or nil if there is none.
+func elementStyle(rules []cssRule, node *html.Node, parent *computedStyle) computedStyle {
+	type candidate struct {
+		declaration CSSDeclaration
+		inline      bool
+		rule        *cssRule
+	}
+
+	// Collect the declarations that apply to node
+	var candidates []candidate
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.selector.Match(node) {
+			continue
+		}
+
+		for _, d := range rule.declarations {
+			if _, supported := cascadedProperties[d.Property]; supported {
+				candidates = append(candidates, candidate{declaration: d, rule: rule})
+			}
+		}
+	}
+
+	if HasAttribute(node, "style") {
+		for _, d := range Style(node).Declarations() {
+			if _, supported := cascadedProperties[d.Property]; supported {
+				candidates = append(candidates, candidate{declaration: d, inline: true})
+			}
+		}
+	}
+
+	// Sort from the lowest precedence to the highest, so the
+	// last candidate for a property wins.
+	rank := func(cand candidate) (bool, bool, cascadia.Specificity, int) {
+		important := cand.declaration.Priority == "important"
+		if cand.inline {
+			return important, true, cascadia.Specificity{}, 0
+		}
+		return important, false, cand.rule.specificity, cand.rule.order
+	}
+
+	if len(candidates) > 1 {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			oi, ii, si, ri := rank(candidates[i])
+			oj, ij, sj, rj := rank(candidates[j])
+			switch {
+			case oi != oj:
+				return !oi
+			case ii != ij:
+				return !ii
+			case si != sj:
+				return si.Less(sj)
+			default:
+				return ri < rj
+			}
+		})
+	}
+
+	// The default browser stylesheet has the lowest precedence
+	userAgent := userAgentStyle(node)
+	cascaded := userAgent
+	for _, cand := range candidates {
+		i := cascadedProperties[cand.declaration.Property].index
+		cascaded[i] = strings.ToLower(cand.declaration.Value)
+	}
+
+	var computed computedStyle
+	for _, prop := range cascadedProperties {
+		value := cascaded[prop.index]
+		if value == "revert" {
+			value = userAgent[prop.index]
+		}
+
+		switch {
+		case value == "inherit", value == "unset" && prop.inherited, value == "" && prop.inherited:
+			if parent != nil {
+				value = parent[prop.index]
+			} else {
+				value = prop.initial
+			}
+		case value == "initial", value == "unset", value == "":
+			value = prop.initial
+		}
+
+		computed[prop.index] = value
+	}
+
+	return computed
+}
+
+// ComputedStyle returns the computed value of the specified property of
+// node, using the <style> elements in its document. Only display,
+// visibility, content-visibility and white-space are supported. Since the
+// stylesheets are parsed on every call, use NewCascade() when computing
+// the style of many elements.
+func ComputedStyle(node *html.Node, property string) string {
+	if node == nil {
+		return ""
+	}
+
+	return NewCascade(rootNode(node)).ComputedStyle(node, property)
+}
+
+// InnerTextWithStyles works like InnerText, except the style of elements
+// is resolved using the <style> elements of the document as well, so the
+// elements hidden by class rules inside stylesheet are excluded too.
+func InnerTextWithStyles(node *html.Node) string {
+	if node == nil {
+		return ""
+	}
+
+	return NewCascade(rootNode(node)).InnerText(node)
+}
+
+// parseStyleSheet parses the style rules inside a stylesheet. Rules inside
+// at-rules are ignored, except @media with an unconditional media query.
+func parseStyleSheet(text string, order int) []cssRule {
+	var rules []cssRule
+	text = stripCSSComments(text)
+
+	for len(text) > 0 {
+		prelude, block, rest, isBlock := nextCSSRule(text)
+		text = rest
+
+		prelude = strings.TrimSpace(prelude)
+		if !isBlock || prelude == "" {
+			continue
+		}
+
+		if prelude[0] == '@' {
+			name := prelude
+			if i := strings.IndexAny(prelude, " \t\r\n\f("); i >= 0 {
+				name = prelude[:i]
+			}
+
+			if strings.EqualFold(name, "@media") && matchMediaQuery(prelude[len(name):]) {
+				nested := parseStyleSheet(block, order)
+				rules = append(rules, nested...)
+				order += len(nested)
+			}
+			continue
+		}
+
+		// Invalid selector makes the whole rule invalid
+		group, err := cascadia.ParseGroupWithPseudoElements(prelude)
+		if err != nil {
+			continue
+		}
+
+		declarations := ParseStyleDeclarations(block)
+		for _, sel := range group {
+			if sel.PseudoElement() != "" {
+				continue
+			}
+
+			rules = append(rules, cssRule{
+				selector:     sel,
+				specificity:  sel.Specificity(),
+				declarations: declarations,
+				order:        order,
+			})
+			order++
+		}
+	}
+
+	return rules
+}
+
+// nextCSSRule splits the first rule from text. If the rule has a block,
+// prelude is the text before the block and block is its content.
+// Otherwise it's a statement which ends with semicolon, e.g. @import.
+func nextCSSRule(text string) (prelude, block, rest string, isBlock bool) {
+	var (
+		quote byte
+		depth int
+		start = -1
+	)
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			i++
+
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+
+		case c == '"' || c == '\'':
+			quote = c
+
+		case c == '(' || c == '[':
+			depth++
+
+		case c == ')' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+
+		case c == ';' && depth == 0 && start < 0:
+			return text[:i], "", text[i+1:], false
+
+		case c == '{':
+			if start < 0 {
+				start, depth = i, 0
+			} else {
+				depth++
+			}
+
+		case c == '}' && start >= 0:
+			if depth == 0 {
+				return text[:start], text[start+1 : i], text[i+1:], true
+			}
+			depth--
+		}
+	}
+
+	// Unclosed block is closed at the end of stylesheet
+	if start >= 0 {
+		return text[:start], text[start+1:], "", true
+	}
+	return text, "", "", false
+}
+
+// stripCSSComments removes comments outside of strings.
+func stripCSSComments(text string) string {
+	if !strings.Contains(text, "/*") {
+		return text
+	}
+
+	var sb strings.Builder
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			sb.WriteString(text[i : i+2])
+			i++
+
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			sb.WriteByte(c)
+
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return sb.String()
+			}
+			i += end + 3
+			sb.WriteByte(' ')
+
+		case c == '"' || c == '\'':
+			quote = c
+			sb.WriteByte(c)
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// matchMediaQuery reports whether the media query list applies to screen
+// unconditionally, i.e. it's empty or one of its queries is just `all` or
+// `screen` (optionally prefixed with `only`).
+func matchMediaQuery(query string) bool {
+	if strings.TrimSpace(query) == "" {
+		return true
+	}
+
+	for _, q := range strings.Split(strings.ToLower(query), ",") {
+		fields := strings.Fields(q)
+		if len(fields) > 0 && fields[0] == "only" {
+			fields = fields[1:]
+		}
+
+		if len(fields) == 1 && (fields[0] == "all" || fields[0] == "screen") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package dom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+const cascadeTestHTML = `<html><head>
+<style>
+	/* comment with { brace */
+	.hidden { display: none }
+	#main .ad, .sponsor::before { display: none; }
+	.shown.hidden { display: block }
+	.invisible { visibility: hidden }
+	.visible { visibility: visible }
+	.important { display: none !important }
+	p.override { display: block }
+	.lazy { content-visibility: hidden }
+	.pre { white-space: pre-wrap }
+	.inherit { display: inherit }
+	.revert { display: revert }
+	div[ { display: none }
+	@media print { .print-only { display: none } }
+	@media screen { .screen-hidden { display: none } }
+	@media (max-width: 600px) { .mobile-hidden { display: none } }
+	@font-face { font-family: x; src: url(x.woff) }
+	@import url("other.css");
+</style>
+<style media="print">.print-style { display: none }</style>
+</head><body>
+<div id="main">
+	<p id="text">Visible</p>
+	<p id="hidden" class="hidden">Hidden by class</p>
+	<p id="shown" class="hidden shown">Shown by specificity</p>
+	<p id="ad" class="ad">Ad</p>
+	<p id="sponsor" class="sponsor">Sponsor</p>
+	<p id="inline" class="hidden" style="display: block">Inline wins</p>
+	<p id="important" class="important override" style="display: block">Important wins</p>
+	<div id="invisible" class="invisible">Invisible <span id="visible" class="visible">but child visible</span></div>
+	<div id="lazy" class="lazy"><p>Lazy content</p></div>
+	<div id="pre" class="pre"><span id="pre-child">Pre</span></div>
+	<pre id="pre-ua">Preformatted</pre>
+	<div class="hidden"><span id="inherit" class="inherit">Inherit</span></div>
+	<p id="revert" class="revert">Revert</p>
+	<p id="print-only" class="print-only print-style">Print</p>
+	<p id="screen-hidden" class="screen-hidden">Screen hidden</p>
+	<p id="mobile-hidden" class="mobile-hidden">Mobile hidden</p>
+	<p id="attr" hidden>Hidden attribute</p>
+	<span id="span">Span</span>
+</div>
+</body></html>`
+
+func TestComputedStyle(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(cascadeTestHTML))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	tests := []struct {
+		id       string
+		property string
+		want     string
+	}{
+		{"text", "display", "block"},
+		{"hidden", "display", "none"},
+		{"shown", "display", "block"},
+		{"ad", "display", "none"},
+		{"sponsor", "display", "block"},
+		{"inline", "display", "block"},
+		{"important", "display", "none"},
+		{"invisible", "visibility", "hidden"},
+		{"visible", "visibility", "visible"},
+		{"lazy", "content-visibility", "hidden"},
+		{"pre-child", "white-space", "pre-wrap"},
+		{"pre-ua", "white-space", "pre"},
+		{"text", "white-space", "normal"},
+		{"inherit", "display", "none"},
+		{"revert", "display", "block"},
+		{"print-only", "display", "block"},
+		{"screen-hidden", "display", "none"},
+		{"mobile-hidden", "display", "block"},
+		{"attr", "display", "none"},
+		{"span", "display", "inline"},
+		{"span", "DISPLAY", "inline"},
+		{"span", "color", ""},
+	}
+
+	cascade := dom.NewCascade(doc)
+	for _, tt := range tests {
+		t.Run(tt.id+" "+tt.property, func(t *testing.T) {
+			node := dom.GetElementByID(doc, tt.id)
+			if got := cascade.ComputedStyle(node, tt.property); got != tt.want {
+				t.Errorf("Cascade.ComputedStyle() = %q, want %q", got, tt.want)
+			}
+
+			if got := dom.ComputedStyle(node, tt.property); got != tt.want {
+				t.Errorf("ComputedStyle() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Text node inherits from its parent
+	text := dom.GetElementByID(doc, "visible").FirstChild
+	if got := cascade.ComputedStyle(text, "visibility"); got != "visible" {
+		t.Errorf("Cascade.ComputedStyle() of text = %q, want %q", got, "visible")
+	}
+}
+
+func TestInnerTextWithStyles(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(cascadeTestHTML))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	main := dom.GetElementByID(doc, "main")
+	got := dom.InnerTextWithStyles(main)
+
+	for _, want := range []string{"Visible", "Shown by specificity", "Sponsor", "Inline wins",
+		"but child visible", "Pre", "Revert", "Print", "Mobile hidden", "Span"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("InnerTextWithStyles() = %q, want to contain %q", got, want)
+		}
+	}
+
+	for _, unwanted := range []string{"Hidden by class", "Ad", "Important wins", "Invisible",
+		"Lazy content", "Inherit", "Screen hidden", "Hidden attribute"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("InnerTextWithStyles() = %q, want not to contain %q", got, unwanted)
+		}
+	}
+
+	// InnerText only knows inline style and hidden attribute
+	if plain := dom.InnerText(main); !strings.Contains(plain, "Hidden by class") {
+		t.Errorf("InnerText() = %q, want to contain %q", plain, "Hidden by class")
+	}
+}
@@ -0,0 +1,124 @@
+package dom
+
+import (
+	"iter"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DOMStringMap is a live view of the custom data attributes (data-*) of
+// an element, just like the dataset property in browser. The keys are
+// converted from the attribute names, e.g. data-lazy-src becomes lazySrc.
+type DOMStringMap struct {
+	node *html.Node
+}
+
+// Dataset returns the custom data attributes of node.
+func Dataset(node *html.Node) *DOMStringMap {
+	return &DOMStringMap{node: node}
+}
+
+// Get returns the value of the data attribute with the specified key,
+// and reports whether it exists.
+func (m *DOMStringMap) Get(key string) (string, bool) {
+	for key2, value := range m.All() {
+		if key2 == key {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of the data attribute with the specified key. Keys
+// which contain a hyphen followed by lowercase letter (e.g. "foo-bar")
+// can't be represented as attribute name, so they are ignored.
+func (m *DOMStringMap) Set(key, value string) {
+	if attrName, ok := datasetAttrName(key); ok {
+		SetAttribute(m.node, attrName, value)
+	}
+}
+
+// Delete removes the data attribute with the specified key.
+func (m *DOMStringMap) Delete(key string) {
+	if attrName, ok := datasetAttrName(key); ok {
+		RemoveAttribute(m.node, attrName)
+	}
+}
+
+// Keys returns the keys of all data attributes in document order.
+func (m *DOMStringMap) Keys() []string {
+	var keys []string
+	for key := range m.All() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Len returns the number of data attributes.
+func (m *DOMStringMap) Len() int {
+	return len(m.Keys())
+}
+
+// All returns an iterator over the keys and values of all data
+// attributes in document order.
+func (m *DOMStringMap) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if m.node == nil {
+			return
+		}
+
+		for _, attr := range m.node.Attr {
+			if key, ok := datasetKey(attr.Key); ok && !yield(key, attr.Val) {
+				return
+			}
+		}
+	}
+}
+
+// datasetKey converts the name of data attribute into dataset key,
+// e.g. data-foo-bar into fooBar.
+func datasetKey(attrName string) (string, bool) {
+	if !strings.HasPrefix(attrName, "data-") || strings.IndexFunc(attrName, isASCIIUpper) >= 0 {
+		return "", false
+	}
+
+	name := attrName[len("data-"):]
+	var sb strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '-' && i+1 < len(name) && name[i+1] >= 'a' && name[i+1] <= 'z' {
+			sb.WriteByte(name[i+1] - 'a' + 'A')
+			i++
+			continue
+		}
+		sb.WriteByte(name[i])
+	}
+
+	return sb.String(), true
+}
+
+// datasetAttrName converts dataset key into the name of data attribute,
+// e.g. fooBar into data-foo-bar.
+func datasetAttrName(key string) (string, bool) {
+	var sb strings.Builder
+	sb.WriteString("data-")
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == '-' && i+1 < len(key) && key[i+1] >= 'a' && key[i+1] <= 'z':
+			return "", false
+		case isASCIIUpper(rune(c)):
+			sb.WriteByte('-')
+			sb.WriteByte(c - 'A' + 'a')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String(), true
+}
+
+func isASCIIUpper(r rune) bool {
+	return r >= 'A' && r <= 'Z'
+}
@@ -0,0 +1,83 @@
+package dom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestDataset(t *testing.T) {
+	doc, err := parseHTMLSource(`<img id="img" src="placeholder.gif" data-src="a.jpg" data-lazy-srcset="a.jpg 1x" data-original="b.jpg" data-foo--bar="x" data-="empty">`)
+	if err != nil {
+		t.Errorf("Dataset, failed to parse: %v", err)
+	}
+
+	img := dom.GetElementByID(doc, "img")
+	dataset := dom.Dataset(img)
+
+	t.Run("Keys", func(t *testing.T) {
+		want := "src,lazySrcset,original,foo-Bar,"
+		if got := strings.Join(dataset.Keys(), ","); got != want {
+			t.Errorf("DOMStringMap.Keys() = %q, want %q", got, want)
+		}
+
+		if got := dataset.Len(); got != 5 {
+			t.Errorf("DOMStringMap.Len() = %v, want %v", got, 5)
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		tests := map[string]string{
+			"src":         "a.jpg",
+			"lazySrcset":  "a.jpg 1x",
+			"foo-Bar":     "x",
+			"lazy-srcset": "",
+			"missing":     "",
+		}
+
+		for key, want := range tests {
+			if got, _ := dataset.Get(key); got != want {
+				t.Errorf("DOMStringMap.Get(%q) = %q, want %q", key, got, want)
+			}
+		}
+
+		if _, ok := dataset.Get("lazy-srcset"); ok {
+			t.Errorf("DOMStringMap.Get(%q) exists, want not exists", "lazy-srcset")
+		}
+	})
+
+	t.Run("Set and Delete", func(t *testing.T) {
+		dataset.Set("lazySrc", "c.jpg")
+		if got := dom.GetAttribute(img, "data-lazy-src"); got != "c.jpg" {
+			t.Errorf("DOMStringMap.Set() = %q, want %q", got, "c.jpg")
+		}
+
+		dataset.Set("src", "d.jpg")
+		if got := dom.GetAttribute(img, "data-src"); got != "d.jpg" {
+			t.Errorf("DOMStringMap.Set() = %q, want %q", got, "d.jpg")
+		}
+
+		// Invalid key is ignored
+		nAttr := len(img.Attr)
+		dataset.Set("lazy-src", "e.jpg")
+		if len(img.Attr) != nAttr {
+			t.Errorf("DOMStringMap.Set() with invalid key adds attribute")
+		}
+
+		dataset.Delete("lazySrcset")
+		dataset.Delete("original")
+		if dom.HasAttribute(img, "data-lazy-srcset") || dom.HasAttribute(img, "data-original") {
+			t.Errorf("DOMStringMap.Delete() doesn't remove attribute")
+		}
+
+		want := "src,foo-Bar,,lazySrc"
+		if got := strings.Join(dataset.Keys(), ","); got != want {
+			t.Errorf("DOMStringMap.Keys() = %q, want %q", got, want)
+		}
+
+		if got := dom.GetAttribute(img, "src"); got != "placeholder.gif" {
+			t.Errorf("DOMStringMap changes other attribute: %q", got)
+		}
+	})
+}
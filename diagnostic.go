@@ -0,0 +1,367 @@
+package dom
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DiagnosticCode tells what kind of problem is found in a document.
+type DiagnosticCode int
+
+const (
+	// DiagnosticMisnestedTag means an end tag closes an element while some
+	// of its descendants are still open, e.g. </b> in `<b><i>text</b></i>`.
+	DiagnosticMisnestedTag DiagnosticCode = iota + 1
+
+	// DiagnosticStrayEndTag means an end tag doesn't have any open element
+	// to close, e.g. </div> in `<p>text</p></div>`.
+	DiagnosticStrayEndTag
+
+	// DiagnosticFosterParented means a content is put directly inside a
+	// table where it's not allowed, so the parser moves it before the table,
+	// e.g. <p> in `<table><p>text</p><tr>...</tr></table>`.
+	DiagnosticFosterParented
+
+	// DiagnosticDuplicateID means an element uses the same id with
+	// another element before it.
+	DiagnosticDuplicateID
+)
+
+// String returns the name of the diagnostic code.
+func (c DiagnosticCode) String() string {
+	switch c {
+	case DiagnosticMisnestedTag:
+		return "misnested-tag"
+	case DiagnosticStrayEndTag:
+		return "stray-end-tag"
+	case DiagnosticFosterParented:
+		return "foster-parented"
+	case DiagnosticDuplicateID:
+		return "duplicate-id"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a problem found in a document, which is silently repaired
+// by the parser. The parsed tree might not be what the author meant
+// around the location of the problem.
+type Diagnostic struct {
+	Code    DiagnosticCode
+	Message string
+
+	// Range is the location of the problematic tag or text in the source.
+	Range SourceRange
+
+	// Node is the element which the problem is about, e.g. the element
+	// with duplicate id or the element closed by misnested end tag.
+	// It's nil if there are no such element in the parsed tree.
+	Node *html.Node
+}
+
+// String returns the diagnostic in "line:column: code: message" format.
+func (d Diagnostic) String() string {
+	start := d.Range.Start
+	return fmt.Sprintf("%d:%d: %s: %s", start.Line, start.Column, d.Code, d.Message)
+}
+
+// ParseWithDiagnostics works like ParseWithOptions, but it also returns the
+// problems found in the malformed markup, sorted by their location. This is
+// useful to detect badly broken pages, whose extracted content might be
+// unreliable.
+//
+// The problems are detected by tokenizing the document, which doesn't
+// follow every rule of the HTML parser, so this is only a best effort and
+// not a validator. The source positions are always tracked for the
+// diagnostics, but they're only kept if opts.TrackPositions is set.
+func ParseWithDiagnostics(r io.Reader, opts ParseOptions) (*html.Node, *ParseInfo, []Diagnostic, error) {
+	var diags diagnosticCollector
+	doc, info, err := parseWithOptions(r, opts, &diags)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return doc, info, diags.items, nil
+}
+
+// diagnosticCollector collects the diagnostics while parsing a document.
+// Its methods are safe to be called on nil collector.
+type diagnosticCollector struct {
+	items []Diagnostic
+
+	// markers contains the marker index of the element
+	// for each diagnostic, or -1 if there are none.
+	markers []int
+}
+
+// add appends a diagnostic about the element with the specified
+// marker index, which is resolved into node once parsing is finished.
+func (c *diagnosticCollector) add(code DiagnosticCode, rng SourceRange, marker int, format string, args ...interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.items = append(c.items, Diagnostic{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Range:   rng,
+	})
+	c.markers = append(c.markers, marker)
+}
+
+// finish resolves the nodes of the diagnostics, checks the parsed tree
+// of doc for duplicate ids, then sorts the diagnostics by their location.
+// The nodes and their positions are indexed by their marker.
+func (c *diagnosticCollector) finish(doc *html.Node, nodes []*html.Node, positions []NodePosition) {
+	if c == nil {
+		return
+	}
+
+	for i, marker := range c.markers {
+		if marker >= 0 && marker < len(nodes) {
+			c.items[i].Node = nodes[marker]
+		}
+	}
+
+	// Elements cloned by the parser share the id of the original
+	// element, so they're only counted once.
+	seenIDs := make(map[string]*html.Node)
+	for i, node := range nodes {
+		if node == nil || rootNode(node) != doc {
+			continue
+		}
+
+		id := strings.TrimSpace(GetAttribute(node, "id"))
+		if id == "" {
+			continue
+		}
+
+		first, exist := seenIDs[id]
+		if !exist {
+			seenIDs[id] = node
+			continue
+		}
+
+		c.add(DiagnosticDuplicateID, positions[i].StartTag, i, "id %q is already used by <%s>", id, TagName(first))
+		c.items[len(c.items)-1].Node = node
+	}
+
+	sort.SliceStable(c.items, func(i, j int) bool {
+		return c.items[i].Range.Start.Offset < c.items[j].Range.Start.Offset
+	})
+}
+
+// impliedEndTags are the elements whose end tag can be omitted,
+// so leaving them open is not considered as misnested.
+var impliedEndTags = map[string]struct{}{
+	"dd": {}, "dt": {}, "li": {}, "optgroup": {}, "option": {}, "p": {},
+	"rb": {}, "rp": {}, "rt": {}, "rtc": {}, "caption": {}, "colgroup": {},
+	"tbody": {}, "td": {}, "tfoot": {}, "th": {}, "thead": {}, "tr": {},
+	"html": {}, "head": {}, "body": {},
+}
+
+// formattingElements are the elements which are reopened by the parser
+// when they're closed by misnested end tag.
+var formattingElements = map[string]struct{}{
+	"a": {}, "b": {}, "big": {}, "code": {}, "em": {}, "font": {}, "i": {},
+	"nobr": {}, "s": {}, "small": {}, "strike": {}, "strong": {}, "tt": {}, "u": {},
+}
+
+// paragraphClosers are the start tags which close the open <p>, as long
+// as it's not separated from them by an element in paragraphScope.
+var paragraphClosers = map[string]struct{}{
+	"address": {}, "article": {}, "aside": {}, "blockquote": {}, "center": {},
+	"dd": {}, "details": {}, "dialog": {}, "dir": {}, "div": {}, "dl": {},
+	"dt": {}, "fieldset": {}, "figcaption": {}, "figure": {}, "footer": {},
+	"form": {}, "h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {},
+	"header": {}, "hgroup": {}, "hr": {}, "li": {}, "listing": {}, "main": {},
+	"menu": {}, "nav": {}, "ol": {}, "p": {}, "plaintext": {}, "pre": {},
+	"search": {}, "section": {}, "summary": {}, "table": {}, "ul": {}, "xmp": {},
+}
+
+// paragraphScope are the elements which hide the open <p> outside of
+// them from paragraphClosers, e.g. <p> outside of table cell.
+var paragraphScope = map[string]struct{}{
+	"applet": {}, "button": {}, "caption": {}, "html": {}, "marquee": {},
+	"object": {}, "table": {}, "td": {}, "template": {}, "th": {},
+}
+
+// specialElements are the elements which stop the search for the open
+// list item that's closed by <li>, <dd> or <dt>. The void elements are
+// left out since they're never open.
+var specialElements = map[string]struct{}{
+	"applet": {}, "article": {}, "aside": {}, "blockquote": {}, "body": {},
+	"button": {}, "caption": {}, "center": {}, "colgroup": {}, "dd": {},
+	"details": {}, "dir": {}, "dl": {}, "dt": {}, "fieldset": {},
+	"figcaption": {}, "figure": {}, "footer": {}, "form": {}, "frameset": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {}, "head": {},
+	"header": {}, "hgroup": {}, "html": {}, "iframe": {}, "li": {},
+	"listing": {}, "main": {}, "marquee": {}, "menu": {}, "nav": {},
+	"noembed": {}, "noframes": {}, "noscript": {}, "object": {}, "ol": {},
+	"plaintext": {}, "pre": {}, "script": {}, "search": {}, "section": {},
+	"select": {}, "style": {}, "summary": {}, "table": {}, "tbody": {},
+	"td": {}, "template": {}, "textarea": {}, "tfoot": {}, "th": {},
+	"thead": {}, "title": {}, "tr": {}, "ul": {}, "xmp": {},
+}
+
+// closeImpliedTags removes the open elements whose end tag is implied by
+// the start tag with the specified name, e.g. <p> before <div> or <li>
+// before another <li>, so the end tags which come later are matched to
+// the same element as the parser does.
+func closeImpliedTags(openTags []openTag, name string) []openTag {
+	listItemScope := func(tagName string) bool {
+		_, special := specialElements[tagName]
+		return special && tagName != "address" && tagName != "div" && tagName != "p"
+	}
+
+	switch name {
+	case "li":
+		openTags = closeOpenTag(openTags, listItemScope, "li")
+	case "dd", "dt":
+		openTags = closeOpenTag(openTags, listItemScope, "dd", "dt")
+	case "option":
+		openTags = closeCurrentTag(openTags, "option")
+	case "optgroup":
+		openTags = closeCurrentTag(openTags, "option")
+		openTags = closeCurrentTag(openTags, "optgroup")
+	case "td", "th":
+		openTags = clearTableContext(openTags, "tr", "tbody", "thead", "tfoot", "table")
+	case "tr":
+		openTags = clearTableContext(openTags, "tbody", "thead", "tfoot", "table")
+	case "caption", "colgroup", "tbody", "thead", "tfoot":
+		openTags = clearTableContext(openTags, "table")
+	case "rb", "rtc":
+		openTags = closeOpenTag(openTags, rubyScope, "rb", "rp", "rt", "rtc")
+	case "rp", "rt":
+		openTags = closeOpenTag(openTags, rubyScope, "rb", "rp", "rt")
+	}
+
+	switch name {
+	case "a", "button", "nobr":
+		// These can't be nested, so the open one is closed first
+		openTags = closeOpenTag(openTags, isInDefaultScope, name)
+	case "table":
+		if len(openTags) > 0 && isTableContext(openTags[len(openTags)-1].name) {
+			openTags = closeOpenTag(openTags, isInDefaultScope, "table")
+		}
+	}
+
+	if _, closesParagraph := paragraphClosers[name]; closesParagraph {
+		openTags = closeOpenTag(openTags, func(tagName string) bool {
+			_, inScope := paragraphScope[tagName]
+			return inScope
+		}, "p")
+	}
+
+	// Heading can't be put directly inside another heading
+	if isHeadingTagName(name) && len(openTags) > 0 && isHeadingTagName(openTags[len(openTags)-1].name) {
+		openTags = openTags[:len(openTags)-1]
+	}
+
+	return openTags
+}
+
+// isInDefaultScope reports whether the element with the specified name
+// stops the search for an open element in the default scope.
+func isInDefaultScope(tagName string) bool {
+	_, inScope := paragraphScope[tagName]
+	return inScope && tagName != "button"
+}
+
+// isHeadingTagName reports whether tagName is one of <h1> to <h6>.
+func isHeadingTagName(tagName string) bool {
+	return len(tagName) == 2 && tagName[0] == 'h' && tagName[1] >= '1' && tagName[1] <= '6'
+}
+
+// closeOpenTag closes the nearest open element with one of the specified
+// names, unless an element which stops the search comes first. Like the
+// parser, the formatting elements inside the closed element are reopened.
+func closeOpenTag(openTags []openTag, stop func(string) bool, names ...string) []openTag {
+	for i := len(openTags) - 1; i >= 0; i-- {
+		tagName := openTags[i].name
+		if stringInSlice(tagName, names) {
+			unclosedTags := append([]openTag(nil), openTags[i+1:]...)
+			openTags = openTags[:i]
+			for _, unclosed := range unclosedTags {
+				if _, formatting := formattingElements[unclosed.name]; formatting {
+					openTags = reopenTag(openTags, unclosed)
+				}
+			}
+			return openTags
+		}
+
+		if stop(tagName) {
+			break
+		}
+	}
+
+	return openTags
+}
+
+// closeCurrentTag closes the innermost open element if it has the
+// specified name.
+func closeCurrentTag(openTags []openTag, name string) []openTag {
+	if len(openTags) > 0 && openTags[len(openTags)-1].name == name {
+		return openTags[:len(openTags)-1]
+	}
+	return openTags
+}
+
+// clearTableContext closes the open elements inside the nearest open
+// element with one of the specified names, e.g. the cells and rows inside
+// the table before a new table body. The formatting elements inside the
+// table are not reopened, since the parser doesn't let them leak out of
+// the table cells.
+func clearTableContext(openTags []openTag, names ...string) []openTag {
+	for i := len(openTags) - 1; i >= 0; i-- {
+		switch tagName := openTags[i].name; {
+		case stringInSlice(tagName, names):
+			return openTags[:i+1]
+		case tagName == "html" || tagName == "template":
+			return openTags
+		}
+	}
+	return openTags
+}
+
+// rubyScope stops the search for open ruby annotation at the <ruby>.
+func rubyScope(tagName string) bool {
+	return tagName == "ruby"
+}
+
+// isTableContext reports whether tagName is an element where the parser
+// only accepts table structure.
+func isTableContext(tagName string) bool {
+	switch tagName {
+	case "table", "tbody", "thead", "tfoot", "tr":
+		return true
+	default:
+		return false
+	}
+}
+
+// isAllowedInTable reports whether start tag with the specified name
+// can be put inside table context. The attributes of the tag are read
+// from z, since <input type="hidden"> is allowed as well.
+func isAllowedInTable(tagName string, z *html.Tokenizer, hasAttr bool) bool {
+	switch tagName {
+	case "caption", "colgroup", "col", "tbody", "thead", "tfoot", "tr", "td", "th",
+		"style", "script", "template":
+		return true
+
+	case "input":
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if string(key) == "type" {
+				return strings.EqualFold(string(val), "hidden")
+			}
+		}
+	}
+
+	return false
+}
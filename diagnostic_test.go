@@ -0,0 +1,147 @@
+package dom_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestParseWithDiagnostics(t *testing.T) {
+	tests := []struct {
+		name       string
+		htmlSource string
+		want       []string
+	}{{
+		name: "well formed",
+		htmlSource: "<!DOCTYPE html><html><head><title>Title</title></head><body>\n" +
+			"<div id=\"main\"><p>One<p>Two</div>\n" +
+			"<ul><li>One<li>Two</ul>\n" +
+			"<p>One<ul><li><p>Two<li>Three</li></ul><h1>Title</h1>\n" +
+			"<select><option>A<option>B</option><optgroup><option>C</select>\n" +
+			"<ruby>A<rp>(<rt>a<rp>)</rp></ruby>\n" +
+			"<table>\n<tr><td>A<td>B</tr>\n<tr><th>C</table>\n" +
+			"<table><input type=\"hidden\"><script>var x;</script></table>\n" +
+			"</body></html>",
+		want: nil,
+	}, {
+		name: "foreign content",
+		htmlSource: "<p><svg><![CDATA[ x > <b> </p> ]]><title><b>Title</b></title>" +
+			"<style>a { }</style><g><rect/></g></svg></p>\n" +
+			"<math><mi>x</mi><annotation-xml encoding=\"text/html\"><div>y</div></annotation-xml></math>",
+		want: nil,
+	}, {
+		name:       "foreign content closed by html",
+		htmlSource: "<div><svg><g><p>Text</p></div>",
+		want:       nil,
+	}, {
+		name:       "misnested formatting elements",
+		htmlSource: "<p><b>bold <i>italic</b> text</i></p>",
+		want:       []string{"1:21: misnested-tag <b>"},
+	}, {
+		name:       "misnested block",
+		htmlSource: "<div>\n<span>text</div>",
+		want:       []string{"2:11: misnested-tag <div>"},
+	}, {
+		name:       "stray end tag",
+		htmlSource: "<p>text</p></div>\n</span>",
+		want:       []string{"1:12: stray-end-tag", "2:1: stray-end-tag"},
+	}, {
+		name:       "paragraph closed by block",
+		htmlSource: "<p>a<div>b</div></p>",
+		want:       []string{"1:17: stray-end-tag"},
+	}, {
+		name:       "list item closed by list item",
+		htmlSource: "<ul><li>One<li>Two</li></li></ul>\n<dl><dt>A<dd>B</dd></dt></dl>",
+		want:       []string{"1:24: stray-end-tag", "2:20: stray-end-tag"},
+	}, {
+		name:       "cell closed by row",
+		htmlSource: "<table><tr><td>A<tr><td>B</td></td></tr></table>",
+		want:       []string{"1:31: stray-end-tag"},
+	}, {
+		name:       "foster parented text",
+		htmlSource: "<table>\n  oops<tr><td>Cell</td></tr></table>",
+		want:       []string{"1:8: foster-parented"},
+	}, {
+		name:       "foster parented element",
+		htmlSource: "<table><tbody><div>Moved</div><tr><td>Cell</td></tr><input type=\"text\"></tbody></table>",
+		want:       []string{"1:15: foster-parented <div>", "1:53: foster-parented <input>"},
+	}, {
+		name:       "duplicate id",
+		htmlSource: "<div id=\"a\"></div><p id=\"b\"></p>\n<span id=\"a\"></span><b id=\"a\"></b>",
+		want:       []string{"2:1: duplicate-id <span>", "2:21: duplicate-id <b>"},
+	}, {
+		name:       "cloned element is not duplicate",
+		htmlSource: `<b id="bold"><p>Paragraph</b></p>`,
+		want:       []string{"1:26: misnested-tag <b>"},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, _, diags, err := dom.ParseWithDiagnostics(strings.NewReader(tt.htmlSource), dom.ParseOptions{})
+			if err != nil {
+				t.Fatalf("ParseWithDiagnostics() error = %v", err)
+			}
+
+			var got []string
+			for _, d := range diags {
+				s := fmt.Sprintf("%d:%d: %s", d.Range.Start.Line, d.Range.Start.Column, d.Code)
+				if d.Node != nil {
+					s += " <" + dom.TagName(d.Node) + ">"
+				}
+				got = append(got, s)
+
+				if d.Message == "" {
+					t.Errorf("ParseWithDiagnostics() %s has empty message", s)
+				}
+			}
+
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ParseWithDiagnostics() = %q, want %q", got, tt.want)
+			}
+
+			// Markers used for the diagnostics don't change the document
+			want, err := dom.FastParse(strings.NewReader(tt.htmlSource))
+			if err != nil {
+				t.Fatalf("FastParse() error = %v", err)
+			}
+
+			if dom.OuterHTML(doc) != dom.OuterHTML(want) {
+				t.Errorf("ParseWithDiagnostics() document = %q, want %q", dom.OuterHTML(doc), dom.OuterHTML(want))
+			}
+
+			// Positions are not kept unless requested
+			for node := range dom.Descendants(doc) {
+				if _, ok := dom.SourcePosition(node); ok {
+					t.Errorf("SourcePosition() found without TrackPositions")
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestParseWithDiagnosticsPositions(t *testing.T) {
+	htmlSource := "<div id=\"a\">One</div>\n<div id=\"a\">Two</div>"
+	doc, _, diags, err := dom.ParseWithDiagnostics(strings.NewReader(htmlSource), dom.ParseOptions{TrackPositions: true})
+	if err != nil {
+		t.Fatalf("ParseWithDiagnostics() error = %v", err)
+	}
+	defer dom.ReleaseSourcePositions(doc)
+
+	if len(diags) != 1 {
+		t.Fatalf("ParseWithDiagnostics() = %v, want 1 diagnostic", diags)
+	}
+
+	want := `2:1: duplicate-id: id "a" is already used by <div>`
+	if got := diags[0].String(); got != want {
+		t.Errorf("Diagnostic.String() = %q, want %q", got, want)
+	}
+
+	// Positions are kept as requested
+	position, ok := dom.SourcePosition(diags[0].Node)
+	if !ok || position.StartTag != diags[0].Range {
+		t.Errorf("SourcePosition() = %v, %v, want %v", position.StartTag, ok, diags[0].Range)
+	}
+}
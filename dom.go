@@ -2,40 +2,35 @@ package dom
 
 import (
 	"bytes"
-	"regexp"
+	"errors"
 	"strings"
 
-	"github.com/andybalholm/cascadia"
 	"golang.org/x/net/html"
 )
 
 var (
-	rxPunctuation      = regexp.MustCompile(`\s+([.?!,;])\s*(\S*)`)
-	rxTempNewline      = regexp.MustCompile(`\s*\|\\/\|\s*`)
-	rxDisplayNone      = regexp.MustCompile(`(?i)display:\s*none`)
-	rxVisibilityHidden = regexp.MustCompile(`(?i)visibility:\s*(:?hidden|collapse)`)
+	// ErrNilNode is returned when the node to be read or modified is nil.
+	ErrNilNode = errors.New("node is nil")
+
+	// ErrVoidElement is returned when setting the content of a node
+	// which can't have any children, e.g. <img> or text node.
+	ErrVoidElement = errors.New("node can't have children")
 )
 
 // QuerySelectorAll returns array of document's elements that match
-// the specified group of selectors.
+// the specified group of selectors. If the selectors are invalid, it
+// returns nil. Use QuerySelectorAllErr() to get the parse error.
 func QuerySelectorAll(doc *html.Node, selectors string) []*html.Node {
-	matcher, err := cascadia.ParseGroup(selectors)
-	if err != nil {
-		return nil
-	}
-
-	return cascadia.QueryAll(doc, matcher)
+	nodes, _ := QuerySelectorAllErr(doc, selectors)
+	return nodes
 }
 
 // QuerySelector returns the first document's element that match
-// the specified group of selectors.
+// the specified group of selectors. If the selectors are invalid, it
+// returns nil. Use QuerySelectorErr() to get the parse error.
 func QuerySelector(doc *html.Node, selectors string) *html.Node {
-	matcher, err := cascadia.ParseGroup(selectors)
-	if err != nil {
-		return nil
-	}
-
-	return cascadia.Query(doc, matcher)
+	node, _ := QuerySelectorErr(doc, selectors)
+	return node
 }
 
 // GetElementByID returns a Node object representing the element whose id
@@ -45,27 +40,12 @@ func GetElementByID(doc *html.Node, id string) *html.Node {
 		return nil
 	}
 
-	var results []*html.Node
-	var finder func(*html.Node)
-
-	finder = func(node *html.Node) {
+	for node := range Descendants(doc) {
 		nodeID := GetAttribute(node, "id")
 		nodeID = strings.TrimSpace(nodeID)
 
 		if node.Type == html.ElementNode && nodeID == id {
-			results = append(results, node)
-		}
-
-		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			finder(child)
-		}
-	}
-
-	for child := doc.FirstChild; child != nil; child = child.NextSibling {
-		finder(child)
-
-		if len(results) > 0 {
-			return results[0]
+			return node
 		}
 	}
 
@@ -75,47 +55,17 @@ func GetElementByID(doc *html.Node, id string) *html.Node {
 // GetElementsByClassName returns an array of all child elements which
 // have all of the given class name(s).
 func GetElementsByClassName(doc *html.Node, classNames string) []*html.Node {
-	// Convert class name to map
-	classes := map[string]struct{}{}
-	for _, class := range strings.Fields(classNames) {
-		classes[class] = struct{}{}
-	}
-
-	nClasses := len(classes)
-	if nClasses == 0 {
+	classes := strings.Fields(classNames)
+	if len(classes) == 0 {
 		return nil
 	}
 
-	// Create finder method
+	// Check all nodes
 	var results []*html.Node
-	var finder func(*html.Node)
-	var allClassExist func(*html.Node) bool
-
-	allClassExist = func(node *html.Node) bool {
-		matchCount := 0
-		nodeClasses := GetAttribute(node, "class")
-		for _, nodeClass := range strings.Fields(nodeClasses) {
-			if _, exist := classes[nodeClass]; exist {
-				matchCount++
-			}
-		}
-
-		return matchCount == nClasses
-	}
-
-	finder = func(node *html.Node) {
-		if node.Type == html.ElementNode && allClassExist(node) {
+	for node := range Descendants(doc) {
+		if node.Type == html.ElementNode && hasAllClasses(node, classes) {
 			results = append(results, node)
 		}
-
-		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			finder(child)
-		}
-	}
-
-	// Check all nodes
-	for child := doc.FirstChild; child != nil; child = child.NextSibling {
-		finder(child)
 	}
 
 	return results
@@ -126,20 +76,10 @@ func GetElementsByClassName(doc *html.Node, classNames string) []*html.Node {
 // The special tag "*" will represents all elements.
 func GetElementsByTagName(doc *html.Node, tagName string) []*html.Node {
 	var results []*html.Node
-	var finder func(*html.Node)
-
-	finder = func(node *html.Node) {
+	for node := range Descendants(doc) {
 		if node.Type == html.ElementNode && (tagName == "*" || node.Data == tagName) {
 			results = append(results, node)
 		}
-
-		for child := node.FirstChild; child != nil; child = child.NextSibling {
-			finder(child)
-		}
-	}
-
-	for child := doc.FirstChild; child != nil; child = child.NextSibling {
-		finder(child)
 	}
 
 	return results
@@ -206,6 +146,8 @@ func SetAttribute(node *html.Node, attrName string, attrValue string) {
 			Val: attrValue,
 		})
 	}
+
+	invalidateIndexes(node)
 }
 
 // RemoveAttribute removes attribute with given name.
@@ -222,6 +164,7 @@ func RemoveAttribute(node *html.Node, attrName string) {
 		a := node.Attr
 		a = append(a[:attrIdx], a[attrIdx+1:]...)
 		node.Attr = a
+		invalidateIndexes(node)
 	}
 }
 
@@ -240,102 +183,78 @@ func HasAttribute(node *html.Node, attrName string) bool {
 // and all its descendants.
 func TextContent(node *html.Node) string {
 	var buffer bytes.Buffer
-	var finder func(*html.Node)
-
-	finder = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			buffer.WriteString(n.Data)
-		}
-
-		for child := n.FirstChild; child != nil; child = child.NextSibling {
-			finder(child)
+	for w := newTreeWalk(node, true); w.next(false); {
+		if w.current.Type == html.TextNode {
+			buffer.WriteString(w.current.Data)
 		}
 	}
 
-	finder(node)
 	return buffer.String()
 }
 
-// InnerText in JS used to capture text from an element while excluding text from hidden
-// children. A child is hidden if it's computed width is 0, whether because its CSS (e.g
-// `display: none`, `visibility: hidden`, etc), or if the child has `hidden` attribute.
-// Since we can't compute stylesheet, we only look at `hidden` attribute and inline style.
+// InnerText returns the text of node as it would be rendered by browser,
+// following the steps of HTMLElement.innerText in the HTML specification:
+// block-level elements are separated by line break, paragraphs by blank
+// line, table cells by tab and table rows by line break. White space is
+// collapsed except inside <pre> or elements with preserving white-space,
+// and <br> is converted into newline.
 //
-// Besides excluding text from hidden children, difference between this function and
-// `TextContent` is the latter will skip <br> tag while this function will preserve
-// <br> as newline.
+// Hidden elements are excluded, as well as elements that are never rendered
+// like <script>, <style>, <template> and <noscript>. Since we don't parse
+// stylesheet here, an element is considered hidden only if it has `hidden`
+// attribute or its inline style hides it. Use InnerTextWithStyles to take
+// the <style> elements into account. If node itself is not rendered, its
+// text content is returned instead.
 func InnerText(node *html.Node) string {
-	var buffer bytes.Buffer
-	var finder func(*html.Node)
-
-	finder = func(n *html.Node) {
-		switch n.Type {
-		case html.TextNode:
-			buffer.WriteString(" " + n.Data + " ")
-
-		case html.ElementNode:
-			if n.Data == "br" {
-				buffer.WriteString(`|\/|`)
-				return
-			}
-
-			if HasAttribute(n, "hidden") {
-				return
-			}
-
-			styleAttr := GetAttribute(n, "style")
-			if rxDisplayNone.MatchString(styleAttr) || rxVisibilityHidden.MatchString(styleAttr) {
-				return
-			}
-		}
-
-		for child := n.FirstChild; child != nil; child = child.NextSibling {
-			finder(child)
-		}
-	}
-
-	finder(node)
-	text := buffer.String()
-	text = strings.Join(strings.Fields(text), " ")
-	text = rxPunctuation.ReplaceAllString(text, "$1 $2")
-	text = rxTempNewline.ReplaceAllString(text, "\n")
-	return text
+	return innerText(node, nil)
 }
 
 // OuterHTML returns an HTML serialization of the element and its descendants.
-// The returned HTML value is escaped.
+// The returned HTML value is escaped. If the node can't be rendered, it
+// returns empty string. Use OuterHTMLErr() to get the render error.
 func OuterHTML(node *html.Node) string {
+	outerHTML, _ := OuterHTMLErr(node)
+	return outerHTML
+}
+
+// OuterHTMLErr works like OuterHTML, but it returns the error
+// when the node can't be rendered, or ErrNilNode if node is nil.
+func OuterHTMLErr(node *html.Node) (string, error) {
 	if node == nil {
-		return ""
+		return "", ErrNilNode
 	}
 
 	var buffer bytes.Buffer
-	err := html.Render(&buffer, node)
-	if err != nil {
-		return ""
+	if err := html.Render(&buffer, node); err != nil {
+		return "", err
 	}
 
-	return buffer.String()
+	return buffer.String(), nil
 }
 
 // InnerHTML returns the HTML content (inner HTML) of an element.
-// The returned HTML value is escaped.
+// The returned HTML value is escaped. If the content can't be rendered,
+// it returns empty string. Use InnerHTMLErr() to get the render error.
 func InnerHTML(node *html.Node) string {
-	var err error
-	var buffer bytes.Buffer
+	innerHTML, _ := InnerHTMLErr(node)
+	return innerHTML
+}
 
+// InnerHTMLErr works like InnerHTML, but it returns the error
+// when the content can't be rendered, or ErrNilNode if node is nil.
+func InnerHTMLErr(node *html.Node) (string, error) {
 	if node == nil {
-		return ""
+		return "", ErrNilNode
 	}
 
+	var buffer bytes.Buffer
 	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		err = html.Render(&buffer, child)
-		if err != nil {
-			return ""
+		if err := html.Render(&buffer, child); err != nil {
+			return "", err
 		}
 	}
 
-	return strings.TrimSpace(buffer.String())
+	return strings.TrimSpace(buffer.String()), nil
 }
 
 // DocumentElement returns the Element that is the root element
@@ -367,16 +286,9 @@ func ClassName(node *html.Node) string {
 // Children returns an HTMLCollection of the direct child elements of Node.
 func Children(node *html.Node) []*html.Node {
 	var children []*html.Node
-	if node == nil {
-		return nil
-	}
-
-	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		if child.Type == html.ElementNode {
-			children = append(children, child)
-		}
+	for child := range ElementChildren(node) {
+		children = append(children, child)
 	}
-
 	return children
 }
 
@@ -392,10 +304,8 @@ func ChildNodes(node *html.Node) []*html.Node {
 // FirstElementChild returns the object's first child Element,
 // or nil if there are no child elements.
 func FirstElementChild(node *html.Node) *html.Node {
-	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		if child.Type == html.ElementNode {
-			return child
-		}
+	for child := range ElementChildren(node) {
+		return child
 	}
 	return nil
 }
@@ -416,7 +326,7 @@ func PreviousElementSibling(node *html.Node) *html.Node {
 // the specified one in its parent's children list, or nil if the
 // specified Element is the last one in the list.
 func NextElementSibling(node *html.Node) *html.Node {
-	for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+	for sibling := range FollowingSiblings(node) {
 		if sibling.Type == html.ElementNode {
 			return sibling
 		}
@@ -433,6 +343,7 @@ func AppendChild(node *html.Node, child *html.Node) {
 	if !IsVoidElement(node) {
 		detachChild(child)
 		node.AppendChild(child)
+		invalidateIndexes(child)
 	}
 }
 
@@ -447,6 +358,7 @@ func PrependChild(node *html.Node, child *html.Node) {
 		} else {
 			node.AppendChild(child)
 		}
+		invalidateIndexes(child)
 	}
 }
 
@@ -469,7 +381,8 @@ func ReplaceChild(parent *html.Node, newChild *html.Node, oldChild *html.Node) (
 	// Detach the new child
 	detachChild(newChild)
 	parent.InsertBefore(newChild, oldChild)
-	parent.RemoveChild(oldChild)
+	invalidateIndexes(newChild)
+	removeChild(parent, oldChild)
 	return newChild, oldChild
 }
 
@@ -486,17 +399,24 @@ func IncludeNode(nodeList []*html.Node, node *html.Node) bool {
 // Clone returns a clone of the node and (if specified) its children.
 // However, it will be detached from the original's parents and siblings.
 func Clone(src *html.Node, deep bool) *html.Node {
-	clone := &html.Node{
-		Type:     src.Type,
-		DataAtom: src.DataAtom,
-		Data:     src.Data,
-		Attr:     append([]html.Attribute{}, src.Attr...),
+	clone := shallowClone(src)
+	if !deep {
+		return clone
 	}
 
-	if deep {
-		for child := src.FirstChild; child != nil; child = child.NextSibling {
-			clone.AppendChild(Clone(child, deep))
+	// Keep track of the parent of the next clone while walking the tree
+	parent, last, lastDepth := clone, clone, 0
+	for w := newTreeWalk(src, false); w.next(false); {
+		if w.depth > lastDepth {
+			parent = last
+		}
+
+		for i := w.depth; i < lastDepth; i++ {
+			parent = parent.Parent
 		}
+
+		last, lastDepth = shallowClone(w.current), w.depth
+		parent.AppendChild(last)
 	}
 
 	return clone
@@ -527,21 +447,33 @@ func RemoveNodes(nodeList []*html.Node, filterFn func(*html.Node) bool) {
 		node := nodeList[i]
 		parentNode := node.Parent
 		if parentNode != nil && (filterFn == nil || filterFn(node)) {
-			parentNode.RemoveChild(node)
+			removeChild(parentNode, node)
 		}
 	}
 }
 
-// SetTextContent sets the text content of the specified node.
+// SetTextContent sets the text content of the specified node. Void
+// element is left untouched. Use SetTextContentErr() to know whether
+// the text content is set.
 func SetTextContent(node *html.Node, text string) {
+	SetTextContentErr(node, text)
+}
+
+// SetTextContentErr works like SetTextContent, but it returns ErrNilNode
+// or ErrVoidElement if the text content can't be set.
+func SetTextContentErr(node *html.Node, text string) error {
+	if node == nil {
+		return ErrNilNode
+	}
+
 	if IsVoidElement(node) {
-		return
+		return ErrVoidElement
 	}
 
 	child := node.FirstChild
 	for child != nil {
 		nextSibling := child.NextSibling
-		node.RemoveChild(child)
+		removeChild(node, child)
 		child = nextSibling
 	}
 
@@ -549,33 +481,50 @@ func SetTextContent(node *html.Node, text string) {
 		Type: html.TextNode,
 		Data: text,
 	})
+
+	return nil
 }
 
-// SetInnerHTML sets inner HTML of the specified node.
+// SetInnerHTML sets inner HTML of the specified node. The HTML is parsed
+// using node as the context, so content like <tr> inside <table> is kept
+// as it is. If the HTML can't be parsed, node is not modified. Use
+// SetInnerHTMLErr() to get the error.
 func SetInnerHTML(node *html.Node, rawHTML string) {
+	SetInnerHTMLErr(node, rawHTML)
+}
+
+// SetInnerHTMLErr works like SetInnerHTML, but it returns the error when
+// the HTML can't be parsed. Like SetTextContentErr, it returns ErrNilNode
+// or ErrVoidElement if node can't have children.
+func SetInnerHTMLErr(node *html.Node, rawHTML string) error {
+	if node == nil {
+		return ErrNilNode
+	}
+
+	if IsVoidElement(node) {
+		return ErrVoidElement
+	}
+
 	// Parse raw HTML
-	parsedHTML, err := html.Parse(strings.NewReader(rawHTML))
-	if err != nil || parsedHTML == nil {
-		return
+	children, err := ParseFragment(strings.NewReader(rawHTML), node)
+	if err != nil {
+		return err
 	}
 
 	// Remove node's current children
 	child := node.FirstChild
 	for child != nil {
 		nextSibling := child.NextSibling
-		node.RemoveChild(child)
+		removeChild(node, child)
 		child = nextSibling
 	}
 
-	// Put content of parsed HTML to the node
-	if body := QuerySelector(parsedHTML, "body"); body != nil {
-		bodyChild := body.FirstChild
-		for bodyChild != nil {
-			nextSibling := bodyChild.NextSibling
-			AppendChild(node, bodyChild)
-			bodyChild = nextSibling
-		}
+	// Put the parsed content to the node
+	for _, child := range children {
+		AppendChild(node, child)
 	}
+
+	return nil
 }
 
 // IsVoidElement check whether a node can have any contents or not.
@@ -597,7 +546,111 @@ func IsVoidElement(n *html.Node) bool {
 	}
 }
 
+// treeWalk iterates over a node and its descendants in document order
+// without recursion, so it's safe to use on very deep trees.
+type treeWalk struct {
+	root        *html.Node
+	current     *html.Node
+	depth       int
+	started     bool
+	includeRoot bool
+
+	// maxDepth, if positive, is how deep the walk descends below root.
+	// Deeper nodes are skipped.
+	maxDepth int
+
+	// onLeave, if not nil, is called once the walk is done
+	// with a node and all of its descendants.
+	onLeave func(*html.Node)
+}
+
+func newTreeWalk(root *html.Node, includeRoot bool) *treeWalk {
+	return &treeWalk{
+		root:        root,
+		includeRoot: includeRoot,
+	}
+}
+
+// next moves to the next node and returns false once there are no more
+// nodes. If skipChildren is true, descendants of the current node are
+// skipped.
+func (w *treeWalk) next(skipChildren bool) bool {
+	if !w.started {
+		w.started = true
+		w.current = w.root
+		if w.includeRoot {
+			return w.root != nil
+		}
+		skipChildren = false
+	}
+
+	if w.current == nil {
+		return false
+	}
+
+	if !skipChildren && w.current.FirstChild != nil && (w.maxDepth <= 0 || w.depth < w.maxDepth) {
+		w.current = w.current.FirstChild
+		w.depth++
+		return true
+	}
+
+	for w.current != w.root {
+		w.leave(w.current)
+		if w.current.NextSibling != nil {
+			w.current = w.current.NextSibling
+			return true
+		}
+
+		w.current = w.current.Parent
+		w.depth--
+	}
+
+	if w.includeRoot {
+		w.leave(w.root)
+	}
+
+	w.current = nil
+	return false
+}
+
+func (w *treeWalk) leave(node *html.Node) {
+	if w.onLeave != nil {
+		w.onLeave(node)
+	}
+}
+
+func shallowClone(src *html.Node) *html.Node {
+	return &html.Node{
+		Type:      src.Type,
+		DataAtom:  src.DataAtom,
+		Data:      src.Data,
+		Namespace: src.Namespace,
+		Attr:      append([]html.Attribute{}, src.Attr...),
+	}
+}
+
+// removeChild removes child from parent, and lets the live NodeIterators
+// hasAllClasses reports whether node has all of the classes.
+func hasAllClasses(node *html.Node, classes []string) bool {
+	nodeClasses := strings.Fields(GetAttribute(node, "class"))
+	for _, class := range classes {
+		if !stringInSlice(class, nodeClasses) {
+			return false
+		}
+	}
+	return true
+}
+
+// and Indexes know about it.
+func removeChild(parent, child *html.Node) {
+	beforeNodeRemoved(child)
+	invalidateIndexes(child)
+	parent.RemoveChild(child)
+}
+
 func detachChild(child *html.Node) {
+	beforeNodeRemoved(child)
+	invalidateIndexes(child)
 	if child.Parent != nil || child.PrevSibling != nil || child.NextSibling != nil {
 		if child.Parent != nil {
 			if child.Parent.FirstChild == child {
@@ -622,3 +675,16 @@ func detachChild(child *html.Node) {
 		child.NextSibling = nil
 	}
 }
+
+func stringInSlice(s string, slice []string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
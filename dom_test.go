@@ -1,6 +1,8 @@
 package dom_test
 
 import (
+	"errors"
+	"runtime/debug"
 	"strings"
 	"testing"
 
@@ -167,6 +169,7 @@ func TestGetElementsByClassName(t *testing.T) {
 		<p class="class-a class-b"></p>
 		<p class="class-a class-c"></p>
 		<p class="class-a class-b class-c"></p>
+		<p class="class-d class-e class-d"></p>
 	</div>`
 
 	doc, err := parseHTMLSource(htmlSource)
@@ -183,6 +186,8 @@ func TestGetElementsByClassName(t *testing.T) {
 		"class-a class-c":         2,
 		"class-b class-c":         1,
 		"class-a class-b class-c": 1,
+		"class-d class-e":         1,
+		"class-e class-e":         1,
 	}
 
 	for className, count := range tests {
@@ -1305,6 +1310,224 @@ func TestSetInnerHTML(t *testing.T) {
 	}
 }
 
+func TestSetInnerHTMLContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		htmlSource string
+		newHTML    string
+		want       string
+	}{{
+		name:       "table",
+		htmlSource: `<table id="target"><tr><td>Old</td></tr></table>`,
+		newHTML:    "<tr><td>New</td></tr>",
+		want:       `<table id="target"><tbody><tr><td>New</td></tr></tbody></table>`,
+	}, {
+		name:       "tbody",
+		htmlSource: `<table><tbody id="target"><tr><td>Old</td></tr></tbody></table>`,
+		newHTML:    "<tr><td>A</td></tr><tr><td>B</td></tr>",
+		want:       `<tbody id="target"><tr><td>A</td></tr><tr><td>B</td></tr></tbody>`,
+	}, {
+		name:       "tr",
+		htmlSource: `<table><tr id="target"><td>Old</td></tr></table>`,
+		newHTML:    "<td>A</td><td>B</td>",
+		want:       `<tr id="target"><td>A</td><td>B</td></tr>`,
+	}, {
+		name:       "select",
+		htmlSource: `<select id="target"><option>Old</option></select>`,
+		newHTML:    "<option>One</option><option>Two</option>",
+		want:       `<select id="target"><option>One</option><option>Two</option></select>`,
+	}, {
+		name:       "template",
+		htmlSource: `<div><template id="target"></template></div>`,
+		newHTML:    "<td>Cell</td>",
+		want:       `<template id="target"><td>Cell</td></template>`,
+	}, {
+		name:       "svg",
+		htmlSource: `<svg id="target"></svg>`,
+		newHTML:    `<rect width="1"/><foreignObject><p>Text</p></foreignObject>`,
+		want:       `<svg id="target"><rect width="1"></rect><foreignObject><p>Text</p></foreignObject></svg>`,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseHTMLSource(tt.htmlSource)
+			if err != nil {
+				t.Errorf("SetInnerHTML(), failed to parse: %v", err)
+			}
+
+			target := dom.GetElementByID(doc, "target")
+			dom.SetInnerHTML(target, tt.newHTML)
+			if got := dom.OuterHTML(target); got != tt.want {
+				t.Errorf("SetInnerHTML() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInnerHTMLErr(t *testing.T) {
+	tests := []struct {
+		name string
+		node *html.Node
+		want error
+	}{
+		{"element", dom.CreateElement("div"), nil},
+		{"void element", dom.CreateElement("br"), dom.ErrVoidElement},
+		{"text node", dom.CreateTextNode("text"), dom.ErrVoidElement},
+		{"nil", nil, dom.ErrNilNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dom.SetInnerHTMLErr(tt.node, "<b>Hello</b>")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("SetInnerHTMLErr() error = %v, want %v", err, tt.want)
+			}
+
+			if err == nil && dom.InnerHTML(tt.node) != "<b>Hello</b>" {
+				t.Errorf("SetInnerHTMLErr() = %v, want %v", dom.InnerHTML(tt.node), "<b>Hello</b>")
+			}
+		})
+	}
+
+	// Non-error variant doesn't panic on invalid node
+	dom.SetInnerHTML(nil, "<b>Hello</b>")
+}
+
+func TestSetTextContentErr(t *testing.T) {
+	tests := []struct {
+		name string
+		node *html.Node
+		want error
+	}{
+		{"element", dom.CreateElement("div"), nil},
+		{"void element", dom.CreateElement("img"), dom.ErrVoidElement},
+		{"text node", dom.CreateTextNode("text"), dom.ErrVoidElement},
+		{"nil", nil, dom.ErrNilNode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dom.SetTextContentErr(tt.node, "Hello")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("SetTextContentErr() error = %v, want %v", err, tt.want)
+			}
+
+			if err == nil && dom.TextContent(tt.node) != "Hello" {
+				t.Errorf("SetTextContentErr() = %v, want %v", dom.TextContent(tt.node), "Hello")
+			}
+		})
+	}
+
+	// Non-error variant doesn't panic on invalid node
+	dom.SetTextContent(nil, "Hello")
+}
+
+func TestRenderErr(t *testing.T) {
+	div := dom.CreateElement("div")
+	dom.AppendChild(div, dom.CreateTextNode("Hello"))
+	dom.AppendChild(div, &html.Node{Type: html.ErrorNode})
+
+	if got, err := dom.OuterHTMLErr(div); err == nil {
+		t.Errorf("OuterHTMLErr() = %v, want error", got)
+	}
+
+	if got, err := dom.InnerHTMLErr(div); err == nil {
+		t.Errorf("InnerHTMLErr() = %v, want error", got)
+	}
+
+	if got := dom.OuterHTML(div); got != "" {
+		t.Errorf("OuterHTML() = %v, want empty string", got)
+	}
+
+	// Empty content is not an error
+	if got, err := dom.InnerHTMLErr(dom.CreateElement("div")); got != "" || err != nil {
+		t.Errorf("InnerHTMLErr() = %q, %v, want empty string and no error", got, err)
+	}
+
+	// Nil node is reported like the setters do
+	if _, err := dom.OuterHTMLErr(nil); !errors.Is(err, dom.ErrNilNode) {
+		t.Errorf("OuterHTMLErr(nil) error = %v, want %v", err, dom.ErrNilNode)
+	}
+
+	if _, err := dom.InnerHTMLErr(nil); !errors.Is(err, dom.ErrNilNode) {
+		t.Errorf("InnerHTMLErr(nil) error = %v, want %v", err, dom.ErrNilNode)
+	}
+
+	if got := dom.OuterHTML(nil) + dom.InnerHTML(nil); got != "" {
+		t.Errorf("OuterHTML(nil) + InnerHTML(nil) = %q, want empty string", got)
+	}
+}
+
+func TestDeepTree(t *testing.T) {
+	// With small stack, any recursive traversal will crash the test
+	defer debug.SetMaxStack(debug.SetMaxStack(1 << 20))
+
+	depth := 100000
+	doc := generateDeepTree(depth)
+
+	if node := dom.GetElementByID(doc, "deepest"); dom.TagName(node) != "p" {
+		t.Errorf("GetElementByID() = %v, want p", dom.TagName(node))
+	}
+
+	if got := len(dom.GetElementsByClassName(doc, "level")); got != depth {
+		t.Errorf("GetElementsByClassName() = %v, want %v", got, depth)
+	}
+
+	if got := len(dom.GetElementsByTagName(doc, "div")); got != depth {
+		t.Errorf("GetElementsByTagName() = %v, want %v", got, depth)
+	}
+
+	if got := dom.TextContent(doc); got != "Deep text" {
+		t.Errorf("TextContent() = %v, want %v", got, "Deep text")
+	}
+
+	if got := dom.InnerText(doc); got != "Deep text" {
+		t.Errorf("InnerText() = %v, want %v", got, "Deep text")
+	}
+
+	// Styles of the ancestors are only computed once
+	if got := dom.InnerText(dom.GetElementByID(doc, "deepest")); got != "Deep text" {
+		t.Errorf("InnerText(deepest) = %v, want %v", got, "Deep text")
+	}
+
+	if got := len(dom.QuerySelectorAll(doc, "p")); got != 1 {
+		t.Errorf("QuerySelectorAll() = %v, want 1", got)
+	}
+
+	if got := len(dom.SelectNodes(doc, "//p")); got != 1 {
+		t.Errorf("SelectNodes() = %v, want 1", got)
+	}
+
+	clone := dom.Clone(doc, true)
+	if got := len(dom.GetElementsByTagName(clone, "div")); got != depth {
+		t.Errorf("Clone() has %v divs, want %v", got, depth)
+	}
+
+	if got := dom.TextContent(clone); got != "Deep text" {
+		t.Errorf("Clone() text = %v, want %v", got, "Deep text")
+	}
+}
+
+// generateDeepTree creates a document with the specified number of
+// nested <div>, with a <p> containing text in the deepest one.
+func generateDeepTree(depth int) *html.Node {
+	doc := &html.Node{Type: html.DocumentNode}
+
+	parent := doc
+	for i := 0; i < depth; i++ {
+		div := dom.CreateElement("div")
+		dom.SetAttribute(div, "class", "level")
+		parent.AppendChild(div)
+		parent = div
+	}
+
+	p := dom.CreateElement("p")
+	dom.SetAttribute(p, "id", "deepest")
+	p.AppendChild(dom.CreateTextNode("Deep text"))
+	parent.AppendChild(p)
+	return doc
+}
+
 func parseHTMLSource(htmlSource string) (*html.Node, error) {
 	doc, err := html.Parse(strings.NewReader(htmlSource))
 	if err != nil {
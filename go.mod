@@ -1,6 +1,6 @@
 module github.com/go-shiori/dom
 
-go 1.12
+go 1.24
 
 require (
 	github.com/andybalholm/cascadia v1.2.0
@@ -0,0 +1,244 @@
+package dom
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+)
+
+// Index maps ids, class names, tag names and attribute names of the
+// elements inside a document to the elements themselves, so they can be
+// looked up without scanning the whole tree.
+//
+// The index keeps itself up to date as long as the tree is modified
+// through the functions in this package (AppendChild, SetAttribute,
+// RemoveNodes, etc). Any change inside the indexed tree marks the index
+// as stale and it will be rebuilt on the next lookup. If the tree is
+// modified directly, call Invalidate() or Rebuild() afterwards.
+//
+// Only modifications inside the indexed tree have to notify the index,
+// so live indexes don't slow down modifications of other trees.
+//
+// Index is safe for concurrent lookups. Call Close() once it's not
+// needed anymore, so it's no longer notified about modifications.
+// An index that's never closed is closed once it's garbage collected,
+// so it doesn't keep its tree alive.
+type Index struct {
+	mu      sync.RWMutex
+	root    *html.Node
+	tracker *indexTracker
+	ids     map[string]*html.Node
+	classes map[string][]*html.Node
+	tags    map[string][]*html.Node
+	attrs   map[string][]*html.Node
+	all     []*html.Node
+
+	// order is the position of each element in all, which is used
+	// to intersect the lists of elements as they're in document order.
+	order map[*html.Node]int
+}
+
+// indexTracker marks an Index as stale when its tree is modified. It's
+// kept apart from the Index, so the registered tracker doesn't keep the
+// index reachable.
+type indexTracker struct {
+	stale atomic.Bool
+}
+
+// NewIndex builds an index for the elements inside doc.
+func NewIndex(doc *html.Node) *Index {
+	idx := &Index{root: doc, tracker: &indexTracker{}}
+	idx.build()
+
+	liveIndexes.add(doc, idx.tracker)
+	runtime.SetFinalizer(idx, (*Index).Close)
+	return idx
+}
+
+// Root returns the node whose descendants are indexed.
+func (idx *Index) Root() *html.Node {
+	return idx.root
+}
+
+// GetElementByID returns the first element whose id matches the
+// specified string, like GetElementByID().
+func (idx *Index) GetElementByID(id string) *html.Node {
+	if id == "" {
+		return nil
+	}
+
+	idx.ensure()
+	defer idx.mu.RUnlock()
+	return idx.ids[id]
+}
+
+// GetElementsByClassName returns all elements which have all of the
+// given class name(s) in document order, like GetElementsByClassName().
+func (idx *Index) GetElementsByClassName(classNames string) []*html.Node {
+	classes := strings.Fields(classNames)
+	if len(classes) == 0 {
+		return nil
+	}
+
+	idx.ensure()
+	defer idx.mu.RUnlock()
+
+	// A single class is listed as it is
+	if len(classes) == 1 {
+		return append([]*html.Node(nil), idx.classes[classes[0]]...)
+	}
+
+	// Otherwise start from the rarest class, then drop the elements
+	// which are not listed for the other classes.
+	rarest := idx.classes[classes[0]]
+	for _, class := range classes[1:] {
+		if nodes := idx.classes[class]; len(nodes) < len(rarest) {
+			rarest = nodes
+		}
+	}
+
+	var results []*html.Node
+	for _, node := range rarest {
+		hasAll := true
+		for _, class := range classes {
+			if !idx.contains(idx.classes[class], node) {
+				hasAll = false
+				break
+			}
+		}
+
+		if hasAll {
+			results = append(results, node)
+		}
+	}
+
+	return results
+}
+
+// contains reports whether node is in nodes, which must be a list of
+// indexed elements in document order.
+func (idx *Index) contains(nodes []*html.Node, node *html.Node) bool {
+	order := idx.order[node]
+	i := sort.Search(len(nodes), func(i int) bool {
+		return idx.order[nodes[i]] >= order
+	})
+	return i < len(nodes) && nodes[i] == node
+}
+
+// GetElementsByTagName returns all elements with the specified tag name
+// in document order, like GetElementsByTagName(). The special tag "*"
+// will represents all elements.
+func (idx *Index) GetElementsByTagName(tagName string) []*html.Node {
+	idx.ensure()
+	defer idx.mu.RUnlock()
+
+	if tagName == "*" {
+		return append([]*html.Node(nil), idx.all...)
+	}
+	return append([]*html.Node(nil), idx.tags[tagName]...)
+}
+
+// GetElementsByAttribute returns all elements which have the specified
+// attribute in document order, regardless of its value.
+func (idx *Index) GetElementsByAttribute(attrName string) []*html.Node {
+	idx.ensure()
+	defer idx.mu.RUnlock()
+	return append([]*html.Node(nil), idx.attrs[attrName]...)
+}
+
+// Invalidate marks the index as stale, so it will be rebuilt on the
+// next lookup.
+func (idx *Index) Invalidate() {
+	idx.tracker.stale.Store(true)
+}
+
+// Rebuild rebuilds the index right away.
+func (idx *Index) Rebuild() {
+	idx.mu.Lock()
+	idx.build()
+	idx.mu.Unlock()
+}
+
+// Close stops the index from being notified about modifications.
+// The index still can be used, but it must be kept up to date manually.
+func (idx *Index) Close() {
+	liveIndexes.remove(idx.root, idx.tracker)
+}
+
+// ensure read-locks the index, rebuilding it first if it's stale.
+func (idx *Index) ensure() {
+	idx.mu.RLock()
+	if !idx.tracker.stale.Load() {
+		return
+	}
+	idx.mu.RUnlock()
+
+	idx.mu.Lock()
+	if idx.tracker.stale.Load() {
+		idx.build()
+	}
+	idx.mu.Unlock()
+	idx.mu.RLock()
+}
+
+func (idx *Index) build() {
+	idx.tracker.stale.Store(false)
+	idx.ids = make(map[string]*html.Node)
+	idx.classes = make(map[string][]*html.Node)
+	idx.tags = make(map[string][]*html.Node)
+	idx.attrs = make(map[string][]*html.Node)
+	idx.all = nil
+	idx.order = make(map[*html.Node]int)
+
+	for node := range Descendants(idx.root) {
+		if node.Type != html.ElementNode {
+			continue
+		}
+
+		idx.order[node] = len(idx.all)
+		idx.all = append(idx.all, node)
+		idx.tags[node.Data] = append(idx.tags[node.Data], node)
+
+		for _, attr := range node.Attr {
+			idx.attrs[attr.Key] = append(idx.attrs[attr.Key], node)
+
+			switch attr.Key {
+			case "id":
+				id := strings.TrimSpace(attr.Val)
+				if _, exist := idx.ids[id]; !exist && id != "" {
+					idx.ids[id] = node
+				}
+
+			case "class":
+				seen := map[string]struct{}{}
+				for _, class := range strings.Fields(attr.Val) {
+					if _, exist := seen[class]; !exist {
+						seen[class] = struct{}{}
+						idx.classes[class] = append(idx.classes[class], node)
+					}
+				}
+			}
+		}
+	}
+}
+
+// liveIndexes contains the trackers of Indexes which haven't been closed,
+// so they can be notified when the tree is modified.
+var liveIndexes liveRegistry[*indexTracker]
+
+// invalidateIndexes must be called when node is inserted, removed or
+// has its attributes changed, so the live Indexes that contain the node
+// know they have to be rebuilt.
+func invalidateIndexes(node *html.Node) {
+	if node == nil || node.Type == html.TextNode || node.Type == html.CommentNode {
+		return
+	}
+
+	liveIndexes.each(node, func(tracker *indexTracker) {
+		tracker.stale.Store(true)
+	})
+}
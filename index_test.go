@@ -0,0 +1,210 @@
+package dom_test
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+const indexTestHTML = `<div id="main">
+	<p id="p1" class="a b">One</p>
+	<p id="p2" class="b  a b" data-x="1">Two</p>
+	<span id="s1" class="c" data-x="">Three</span>
+	<p id=" p1 " class="a">Duplicate</p>
+</div>`
+
+func ids(nodes []*html.Node) string {
+	var result []string
+	for _, node := range nodes {
+		result = append(result, strings.TrimSpace(dom.ID(node)))
+	}
+	return strings.Join(result, ",")
+}
+
+func TestIndex(t *testing.T) {
+	doc, err := parseHTMLSource(indexTestHTML)
+	if err != nil {
+		t.Errorf("Index, failed to parse: %v", err)
+	}
+
+	idx := dom.NewIndex(doc)
+	defer idx.Close()
+
+	t.Run("GetElementByID", func(t *testing.T) {
+		for _, id := range []string{"main", "p1", "s1", "missing", ""} {
+			if got, want := idx.GetElementByID(id), dom.GetElementByID(doc, id); got != want {
+				t.Errorf("Index.GetElementByID(%q) = %v, want %v", id, got, want)
+			}
+		}
+	})
+
+	t.Run("GetElementsByClassName", func(t *testing.T) {
+		for _, classes := range []string{"a", "b", "a b", " b  a ", "a a", "b a x", "c", "a c", "x", ""} {
+			got, want := ids(idx.GetElementsByClassName(classes)), ids(dom.GetElementsByClassName(doc, classes))
+			if got != want {
+				t.Errorf("Index.GetElementsByClassName(%q) = %v, want %v", classes, got, want)
+			}
+		}
+	})
+
+	t.Run("GetElementsByTagName", func(t *testing.T) {
+		for _, tag := range []string{"p", "span", "div", "*", "table"} {
+			got, want := ids(idx.GetElementsByTagName(tag)), ids(dom.GetElementsByTagName(doc, tag))
+			if got != want {
+				t.Errorf("Index.GetElementsByTagName(%q) = %v, want %v", tag, got, want)
+			}
+		}
+	})
+
+	t.Run("GetElementsByAttribute", func(t *testing.T) {
+		if got := ids(idx.GetElementsByAttribute("data-x")); got != "p2,s1" {
+			t.Errorf("Index.GetElementsByAttribute() = %v, want %v", got, "p2,s1")
+		}
+	})
+}
+
+func TestIndexMutation(t *testing.T) {
+	doc, err := parseHTMLSource(indexTestHTML)
+	if err != nil {
+		t.Errorf("Index, failed to parse: %v", err)
+	}
+
+	idx := dom.NewIndex(doc)
+	defer idx.Close()
+
+	main := idx.GetElementByID("main")
+	p1 := idx.GetElementByID("p1")
+
+	// Changing attributes
+	dom.SetAttribute(p1, "id", "first")
+	if got := idx.GetElementByID("first"); got != p1 {
+		t.Errorf("Index.GetElementByID() after SetAttribute() = %v, want p1", got)
+	}
+
+	dom.RemoveAttribute(p1, "class")
+	if got := ids(idx.GetElementsByClassName("a")); got != "p2,p1" {
+		t.Errorf("Index.GetElementsByClassName() after RemoveAttribute() = %v, want p2,p1", got)
+	}
+
+	// Adding new element
+	div := dom.CreateElement("div")
+	dom.SetAttribute(div, "id", "new")
+	dom.SetAttribute(div, "class", "a")
+	dom.PrependChild(main, div)
+
+	if got := ids(idx.GetElementsByClassName("a")); got != "new,p2,p1" {
+		t.Errorf("Index.GetElementsByClassName() after PrependChild() = %v, want new,p2,p1", got)
+	}
+
+	// Modifying detached element doesn't affect the index
+	detached := dom.CreateElement("p")
+	dom.SetAttribute(detached, "id", "detached")
+	dom.AppendChild(dom.CreateElement("div"), detached)
+	if got := idx.GetElementByID("detached"); got != nil {
+		t.Errorf("Index.GetElementByID() = %v, want nil", got)
+	}
+
+	// Removing elements
+	dom.RemoveNodes(dom.GetElementsByTagName(doc, "p"), nil)
+	if got := ids(idx.GetElementsByTagName("*")); got != "main,new,s1" {
+		t.Errorf("Index.GetElementsByTagName() after RemoveNodes() = %v, want main,new,s1", got)
+	}
+
+	dom.SetInnerHTML(main, `<b id="b1" class="a">bold</b>`)
+	if got := ids(idx.GetElementsByClassName("a")); got != "b1" {
+		t.Errorf("Index.GetElementsByClassName() after SetInnerHTML() = %v, want b1", got)
+	}
+
+	// Direct modification needs manual invalidation
+	i := dom.CreateElement("i")
+	main.AppendChild(i)
+	if got := len(idx.GetElementsByTagName("i")); got != 0 {
+		t.Errorf("Index.GetElementsByTagName() before Invalidate() = %v, want 0", got)
+	}
+
+	idx.Invalidate()
+	if got := len(idx.GetElementsByTagName("i")); got != 1 {
+		t.Errorf("Index.GetElementsByTagName() after Invalidate() = %v, want 1", got)
+	}
+
+	// Closed index is no longer updated automatically
+	idx.Close()
+	dom.SetAttribute(i, "id", "italic")
+	if got := idx.GetElementByID("italic"); got != nil {
+		t.Errorf("Index.GetElementByID() after Close() = %v, want nil", got)
+	}
+
+	idx.Rebuild()
+	if got := idx.GetElementByID("italic"); got != i {
+		t.Errorf("Index.GetElementByID() after Rebuild() = %v, want i", got)
+	}
+}
+
+func TestIndexConcurrent(t *testing.T) {
+	doc, err := parseHTMLSource(indexTestHTML)
+	if err != nil {
+		t.Errorf("Index, failed to parse: %v", err)
+	}
+
+	idx := dom.NewIndex(doc)
+	defer idx.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				idx.Invalidate()
+				if got := idx.GetElementByID("s1"); dom.TagName(got) != "span" {
+					t.Errorf("Index.GetElementByID() = %v, want span", dom.TagName(got))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIndexWithoutClose(t *testing.T) {
+	// Finalizer doesn't run for node in a cycle, so the root doesn't have
+	// any children here, since they would refer back to it.
+	collected := make(chan struct{})
+	func() {
+		root := dom.CreateElement("div")
+		runtime.SetFinalizer(root, func(*html.Node) { close(collected) })
+		dom.NewIndex(root).GetElementByID("main")
+	}()
+
+	// Index which is never closed doesn't keep its tree alive
+	if !waitCollected(collected) {
+		t.Errorf("root of Index is not garbage collected")
+	}
+}
+
+func BenchmarkGetElementByID(b *testing.B) {
+	doc, err := parseHTMLSource(strings.Repeat(`<div class="a b"><p>text</p></div>`, 1000) + `<p id="last"></p>`)
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+
+	b.Run("NoIndex", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dom.GetElementByID(doc, "last")
+		}
+	})
+
+	b.Run("Index", func(b *testing.B) {
+		idx := dom.NewIndex(doc)
+		defer idx.Close()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			idx.GetElementByID("last")
+		}
+	})
+}
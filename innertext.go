@@ -0,0 +1,384 @@
+package dom
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockLevelDisplays are the display values of block-level boxes.
+var blockLevelDisplays = map[string]struct{}{
+	"block":         {},
+	"flow-root":     {},
+	"list-item":     {},
+	"table":         {},
+	"table-caption": {},
+	"flex":          {},
+	"grid":          {},
+}
+
+// InnerText returns the text of node as it would be rendered, following
+// the steps of HTMLElement.innerText in the HTML specification, with hidden
+// elements detected using the Cascade.
+func (c *Cascade) InnerText(node *html.Node) string {
+	return innerText(node, c)
+}
+
+// innerText collects the rendered text of node. The style of elements is
+// resolved using cascade, or only using the default browser stylesheet and
+// the inline styles if cascade is nil.
+func innerText(node *html.Node, cascade *Cascade) string {
+	if node == nil {
+		return ""
+	}
+
+	// The styles of the ancestors are computed once, from the top most
+	// one, so the styles of node and its descendants can use them.
+	styles := &innerTextStyles{cascade: cascade}
+	styles.pushAncestors(node)
+
+	// Element that's not being rendered returns its text content
+	if node.Type == html.ElementNode && !styles.isRendered(node) {
+		return TextContent(node)
+	}
+
+	var out innerTextWriter
+	w := newTreeWalk(node, true)
+	w.onLeave = func(n *html.Node) {
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		if n != node {
+			out.leave(n, styles)
+		}
+		styles.pop()
+	}
+
+	skipChildren := false
+	for w.next(skipChildren) {
+		n := w.current
+		skipChildren = false
+
+		switch n.Type {
+		case html.TextNode:
+			style := styles.parentStyle(n)
+			if style[visibilityIndex] == "visible" {
+				out.text(n.Data, style[whiteSpaceIndex])
+			}
+
+		case html.ElementNode:
+			style := styles.push(n)
+			if n == node {
+				continue
+			}
+
+			if style[displayIndex] == "none" {
+				skipChildren = true
+				continue
+			}
+
+			// Content of element with content-visibility: hidden is not rendered,
+			// but the element itself is.
+			skipChildren = style[contentVisibilityIndex] == "hidden"
+			out.enter(n, style)
+		}
+	}
+
+	return out.String()
+}
+
+// innerTextStyles resolves the style of the elements for innerText. Without
+// cascade, the styles are only kept for the elements which are currently
+// open in the walk, so nothing has to be cached for the whole document.
+type innerTextStyles struct {
+	cascade *Cascade
+	path    []*html.Node
+	styles  []computedStyle
+}
+
+// push computes the style of element n, whose parent must be the last
+// pushed element, and keeps it until pop is called.
+func (s *innerTextStyles) push(n *html.Node) computedStyle {
+	style := s.style(n)
+	s.path = append(s.path, n)
+	s.styles = append(s.styles, style)
+	return style
+}
+
+// pushAncestors pushes the element ancestors of n, from the top most one.
+func (s *innerTextStyles) pushAncestors(n *html.Node) {
+	var ancestors []*html.Node
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		ancestors = append(ancestors, p)
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		s.push(ancestors[i])
+	}
+}
+
+func (s *innerTextStyles) pop() {
+	s.path = s.path[:len(s.path)-1]
+	s.styles = s.styles[:len(s.styles)-1]
+}
+
+// style returns the computed style of element n.
+func (s *innerTextStyles) style(n *html.Node) computedStyle {
+	if s.cascade != nil {
+		return s.cascade.computedStyle(n)
+	}
+
+	// The elements around the current one can use the open elements
+	for i := len(s.path) - 1; i >= 0 && i >= len(s.path)-3; i-- {
+		switch s.path[i] {
+		case n:
+			return s.styles[i]
+		case n.Parent:
+			return elementStyle(nil, n, &s.styles[i])
+		}
+	}
+
+	// Otherwise compute the ancestors first, from the top most one
+	var chain []*html.Node
+	for p := n; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		chain = append(chain, p)
+	}
+
+	var style computedStyle
+	for i := len(chain) - 1; i >= 0; i-- {
+		if i == len(chain)-1 {
+			style = elementStyle(nil, chain[i], nil)
+		} else {
+			style = elementStyle(nil, chain[i], &style)
+		}
+	}
+	return style
+}
+
+// parentStyle returns the style inherited by n from its parent.
+func (s *innerTextStyles) parentStyle(n *html.Node) computedStyle {
+	if n.Parent == nil || n.Parent.Type != html.ElementNode {
+		return initialStyle
+	}
+	return s.style(n.Parent)
+}
+
+// isRendered reports whether node and all of its ancestors are displayed.
+// The ancestors of node must be the pushed elements.
+func (s *innerTextStyles) isRendered(node *html.Node) bool {
+	if s.style(node)[displayIndex] == "none" {
+		return false
+	}
+
+	for _, style := range s.styles {
+		if style[displayIndex] == "none" {
+			return false
+		}
+	}
+	return true
+}
+
+// childStyle returns the style of element n whose parent has the
+// specified style.
+func (s *innerTextStyles) childStyle(n *html.Node, parent computedStyle) computedStyle {
+	if s.cascade != nil {
+		return s.cascade.computedStyle(n)
+	}
+	return elementStyle(nil, n, &parent)
+}
+
+// isLastTableBox reports whether there are no more boxes with the
+// specified display after n. If checkGroups is true, the following row
+// groups (e.g. <tbody>) of the parent of n are checked as well.
+func (s *innerTextStyles) isLastTableBox(n *html.Node, display string, checkGroups bool) bool {
+	hasBox := func(node *html.Node) bool {
+		return node.Type == html.ElementNode && s.style(node)[displayIndex] == display
+	}
+
+	for sibling := range FollowingSiblings(n) {
+		if hasBox(sibling) {
+			return false
+		}
+	}
+
+	if !checkGroups || n.Parent == nil || n.Parent.Type != html.ElementNode {
+		return true
+	}
+
+	switch s.style(n.Parent)[displayIndex] {
+	case "table-row-group", "table-header-group", "table-footer-group":
+	default:
+		return true
+	}
+
+	for group := range FollowingSiblings(n.Parent) {
+		if group.Type != html.ElementNode {
+			continue
+		}
+
+		groupStyle := s.style(group)
+		if groupStyle[displayIndex] == display {
+			return false
+		}
+
+		for child := range ElementChildren(group) {
+			if s.childStyle(child, groupStyle)[displayIndex] == display {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// innerTextWriter joins the rendered text while it's collected, applying
+// the CSS white space processing rules. Each run of required line break
+// counts is replaced by the maximum count of line feeds, except at the
+// start and the end.
+type innerTextWriter struct {
+	sb           strings.Builder
+	pendingSpace bool
+	breaks       int
+
+	// midLine is true if the last written text doesn't end with a line
+	// feed or tab, so collapsible white space is kept as a space.
+	midLine bool
+}
+
+// enter adds the text which is rendered before the children of element n.
+func (w *innerTextWriter) enter(n *html.Node, style computedStyle) {
+	if style[visibilityIndex] != "visible" {
+		return
+	}
+
+	display := style[displayIndex]
+	switch {
+	case n.Data == "p":
+		w.requireBreaks(2)
+	case display == "table-cell":
+		w.newLine()
+	case isBlockLevelDisplay(display):
+		w.requireBreaks(1)
+	}
+}
+
+// leave adds the text which is rendered after the children of element n,
+// which must be the last element pushed into styles.
+func (w *innerTextWriter) leave(n *html.Node, styles *innerTextStyles) {
+	style := styles.styles[len(styles.styles)-1]
+	display := style[displayIndex]
+	if display == "none" || style[visibilityIndex] != "visible" {
+		return
+	}
+
+	switch {
+	case n.Data == "br":
+		w.preserved("\n")
+	case n.Data == "p":
+		w.requireBreaks(2)
+	case display == "table-cell":
+		// The end of table cell removes collapsible white space
+		// like the end of a line.
+		w.newLine()
+		if !styles.isLastTableBox(n, "table-cell", false) {
+			w.preserved("\t")
+		}
+	case display == "table-row":
+		if !styles.isLastTableBox(n, "table-row", true) {
+			w.preserved("\n")
+		}
+	case isBlockLevelDisplay(display):
+		w.requireBreaks(1)
+	}
+}
+
+// requireBreaks adds a required line break count.
+func (w *innerTextWriter) requireBreaks(count int) {
+	w.newLine()
+	if count > w.breaks {
+		w.breaks = count
+	}
+}
+
+// newLine removes the collapsible white space before it,
+// like at the start of a line.
+func (w *innerTextWriter) newLine() {
+	w.pendingSpace = false
+	w.midLine = false
+}
+
+// preserved adds a text which is used as it is.
+func (w *innerTextWriter) preserved(text string) {
+	w.pendingSpace = false
+	w.write(text)
+}
+
+// text adds a text whose white space is processed according to
+// its white-space property.
+func (w *innerTextWriter) text(text, whiteSpace string) {
+	switch whiteSpace {
+	case "pre", "pre-wrap", "break-spaces":
+		w.write(text)
+		return
+	}
+
+	preserveNewline := whiteSpace == "pre-line"
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		var c byte
+		if i < len(text) {
+			c = text[i]
+		}
+
+		isSpace := i == len(text) || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+		if !isSpace {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			w.write(text[start:i])
+			start = -1
+		}
+
+		switch {
+		case i == len(text):
+		case c == '\n' && preserveNewline:
+			w.pendingSpace = false
+			w.write("\n")
+			w.newLine()
+		case w.midLine:
+			w.pendingSpace = true
+		}
+	}
+}
+
+func (w *innerTextWriter) write(s string) {
+	if w.pendingSpace || s != "" {
+		if w.breaks > 0 && w.sb.Len() > 0 {
+			w.sb.WriteString(strings.Repeat("\n", w.breaks))
+		}
+		w.breaks = 0
+	}
+
+	if w.pendingSpace {
+		w.sb.WriteByte(' ')
+		w.pendingSpace = false
+	}
+
+	w.sb.WriteString(s)
+	w.midLine = !strings.HasSuffix(s, "\n") && !strings.HasSuffix(s, "\t")
+}
+
+// String returns the collected text.
+func (w *innerTextWriter) String() string {
+	return w.sb.String()
+}
+
+func isBlockLevelDisplay(display string) bool {
+	_, exist := blockLevelDisplays[display]
+	return exist
+}
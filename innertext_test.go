@@ -0,0 +1,114 @@
+package dom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestInnerText(t *testing.T) {
+	tests := map[string]string{
+		"plain text":            "Hello world",
+		"collapsed white space": "  Hello \n\t  world  ",
+		"inline elements":       "Hello <b>bold</b> <i> world </i>!",
+		"space across elements": "Hello <b> bold </b> world",
+		"line break":            "One<br>Two<br> Three",
+		"paragraphs":            "<p>One</p><p>Two</p>  <p> Three </p>",
+		"blocks":                "<div>One</div><div>Two <span>still two</span></div>Three",
+		"nested blocks":         "<div><div><p>One</p></div></div><div>Two</div>",
+		"headings and lists":    "<h1>Title</h1><ul><li>One</li><li>Two</li></ul><p>Text</p>",
+		"table":                 "<table><tr><td>A</td><td> B </td></tr><tr><th>C</th><td>D</td></tr></table>",
+		"table sections":        "<table><thead><tr><th>H</th></tr></thead><tbody><tr><td>B</td></tr></tbody><tfoot><tr><td>F</td></tr></tfoot></table>",
+		"pre":                   "<p>Code:</p><pre>  a  b\n    c\n</pre><p>End</p>",
+		"white-space pre":       `<div style="white-space: pre">  keep   this  </div>`,
+		"white-space pre-line":  "<div style=\"white-space: pre-line\">  one   two  \n   three</div>",
+		"non rendered elements": "Text<script>var x;</script><style>p{}</style><template>tpl</template><noscript>no script</noscript> more",
+		"hidden elements":       `<p>Shown</p><p hidden>hidden</p><span style="display:none">none</span><div style="visibility:hidden">invisible <b style="visibility:visible">visible</b></div>`,
+		"display override":      `<p>One</p><div style="display: inline">Two</div><span style="display: block">Three</span>`,
+		"content visibility":    `<div style="content-visibility: hidden">Hidden content</div><div>Shown</div>`,
+		"comment":               "One<!-- comment -->Two",
+		"empty":                 "",
+		"only breaks":           "<p></p><div></div><br>",
+		"punctuation":           "Hello <b>world</b>, how are <i>you</i>?",
+		"nowrap cell":           "<table><tr><td nowrap>  A   B </td><td><textarea>  x  </textarea></td></tr></table>",
+	}
+
+	expected := map[string]string{
+		"plain text":            "Hello world",
+		"collapsed white space": "Hello world",
+		"inline elements":       "Hello bold world !",
+		"space across elements": "Hello bold world",
+		"line break":            "One\nTwo\nThree",
+		"paragraphs":            "One\n\nTwo\n\nThree",
+		"blocks":                "One\nTwo still two\nThree",
+		"nested blocks":         "One\n\nTwo",
+		"headings and lists":    "Title\nOne\nTwo\n\nText",
+		"table":                 "A\tB\nC\tD",
+		"table sections":        "H\nB\nF",
+		"pre":                   "Code:\n\n  a  b\n    c\n\n\nEnd",
+		"white-space pre":       "  keep   this  ",
+		"white-space pre-line":  "one two\nthree",
+		"non rendered elements": "Text more",
+		"hidden elements":       "Shown\n\nvisible",
+		"display override":      "One\n\nTwo\nThree",
+		"content visibility":    "Shown",
+		"comment":               "OneTwo",
+		"empty":                 "",
+		"only breaks":           "\n",
+		"punctuation":           "Hello world, how are you?",
+		"nowrap cell":           "A B\t  x  ",
+	}
+
+	for name, htmlSource := range tests {
+		t.Run(name, func(t *testing.T) {
+			doc, err := parseHTMLSource(htmlSource)
+			if err != nil {
+				t.Errorf("InnerText(), failed to parse: %v", err)
+			}
+
+			if got, want := dom.InnerText(doc), expected[name]; got != want {
+				t.Errorf("InnerText() = %q, want %q", got, want)
+			}
+
+			// Without stylesheet, both give the same result
+			if got, want := dom.InnerTextWithStyles(doc), expected[name]; got != want {
+				t.Errorf("InnerTextWithStyles() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInnerTextNotRendered(t *testing.T) {
+	doc, err := parseHTMLSource(`<div id="hidden" hidden><p>One</p><p>Two</p></div><script id="script">var x = 1;</script>`)
+	if err != nil {
+		t.Errorf("InnerText(), failed to parse: %v", err)
+	}
+
+	// Element which is not rendered returns its text content
+	if got := dom.InnerText(dom.GetElementByID(doc, "hidden")); got != "OneTwo" {
+		t.Errorf("InnerText() = %q, want %q", got, "OneTwo")
+	}
+
+	if got := dom.InnerText(dom.GetElementByID(doc, "script")); got != "var x = 1;" {
+		t.Errorf("InnerText() = %q, want %q", got, "var x = 1;")
+	}
+
+	if got := dom.InnerText(nil); got != "" {
+		t.Errorf("InnerText() = %q, want empty string", got)
+	}
+}
+
+func BenchmarkInnerText(b *testing.B) {
+	block := `<div class="post"><h2>Title</h2><p>Some <b>bold</b> and <a href="#">linked</a> text.</p>` +
+		`<ul><li>One</li><li style="display: none">Two</li></ul></div>`
+	doc, err := parseHTMLSource(strings.Repeat(block, 2000))
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dom.InnerText(doc)
+	}
+}
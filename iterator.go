@@ -0,0 +1,91 @@
+package dom
+
+import (
+	"iter"
+
+	"golang.org/x/net/html"
+)
+
+// TraversalOptions is the options for DescendantsWithOptions.
+type TraversalOptions struct {
+	// MaxDepth limits how deep the traversal descends below the node where
+	// it starts, e.g. 1 only visits the children. Deeper nodes are skipped,
+	// which protects the caller from pathologically deep documents. Zero or
+	// less means unlimited.
+	MaxDepth int
+}
+
+// Descendants returns an iterator over all descendants of node in
+// document order, not including the node itself. The subtree must
+// not be modified while it's iterated.
+func Descendants(node *html.Node) iter.Seq[*html.Node] {
+	return DescendantsWithOptions(node, TraversalOptions{})
+}
+
+// DescendantsWithOptions works like Descendants, but it only visits the
+// descendants allowed by opts. The limit only applies to this iterator,
+// the other functions in this package always visit the whole subtree.
+// To reject too deep documents altogether, use ParseOptions.MaxDepth.
+func DescendantsWithOptions(node *html.Node, opts TraversalOptions) iter.Seq[*html.Node] {
+	return func(yield func(*html.Node) bool) {
+		if node == nil {
+			return
+		}
+
+		w := newTreeWalk(node, false)
+		w.maxDepth = opts.MaxDepth
+		for w.next(false) {
+			if !yield(w.current) {
+				return
+			}
+		}
+	}
+}
+
+// Ancestors returns an iterator over the ancestors of node, starting
+// from its parent up to the root of the tree.
+func Ancestors(node *html.Node) iter.Seq[*html.Node] {
+	return func(yield func(*html.Node) bool) {
+		if node == nil {
+			return
+		}
+
+		for parent := node.Parent; parent != nil; parent = parent.Parent {
+			if !yield(parent) {
+				return
+			}
+		}
+	}
+}
+
+// FollowingSiblings returns an iterator over the nodes that follow
+// node within its parent's children list.
+func FollowingSiblings(node *html.Node) iter.Seq[*html.Node] {
+	return func(yield func(*html.Node) bool) {
+		if node == nil {
+			return
+		}
+
+		for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+			if !yield(sibling) {
+				return
+			}
+		}
+	}
+}
+
+// ElementChildren returns an iterator over the direct child elements
+// of node, i.e. the lazy version of Children().
+func ElementChildren(node *html.Node) iter.Seq[*html.Node] {
+	return func(yield func(*html.Node) bool) {
+		if node == nil {
+			return
+		}
+
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode && !yield(child) {
+				return
+			}
+		}
+	}
+}
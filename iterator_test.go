@@ -0,0 +1,133 @@
+package dom_test
+
+import (
+	"iter"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+const iteratorTestHTML = `<div id="root"><h1 id="title">Title</h1><!--note--><div id="body"><p id="p1">One</p><p id="p2">Two <b id="b">bold</b></p></div><p id="p3">Three</p></div>`
+
+func TestIterators(t *testing.T) {
+	doc, err := parseHTMLSource(iteratorTestHTML)
+	if err != nil {
+		t.Errorf("Iterators, failed to parse: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		seq  func(*html.Node) iter.Seq[*html.Node]
+		node string
+		want string
+	}{
+		{"Descendants", dom.Descendants, "body", "p1,One,p2,Two,b,bold"},
+		{"Descendants of leaf", dom.Descendants, "title", "Title"},
+		{"Ancestors", dom.Ancestors, "b", "p2,body,root,<body>,<html>,<document>"},
+		{"FollowingSiblings", dom.FollowingSiblings, "title", "!note,body,p3"},
+		{"FollowingSiblings of last", dom.FollowingSiblings, "p3", ""},
+		{"ElementChildren", dom.ElementChildren, "root", "title,body,p3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for node := range tt.seq(dom.GetElementByID(doc, tt.node)) {
+				switch {
+				case node.Type == html.DocumentNode:
+					got = append(got, "<document>")
+				case node.Type == html.ElementNode && dom.ID(node) == "":
+					got = append(got, "<"+node.Data+">")
+				default:
+					got = append(got, nodeName(node))
+				}
+			}
+
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("%s() = %v, want %v", tt.name, strings.Join(got, ","), tt.want)
+			}
+
+			// Nil node gives empty iterator
+			for node := range tt.seq(nil) {
+				t.Errorf("%s(nil) yields %v, want nothing", tt.name, nodeName(node))
+			}
+		})
+	}
+}
+
+func TestIteratorsEarlyTermination(t *testing.T) {
+	doc, err := parseHTMLSource(iteratorTestHTML)
+	if err != nil {
+		t.Errorf("Iterators, failed to parse: %v", err)
+	}
+
+	count := 0
+	for node := range dom.Descendants(doc) {
+		count++
+		if dom.ID(node) == "title" {
+			break
+		}
+	}
+
+	// body > div#root > h1#title
+	if count != 2 {
+		t.Errorf("Descendants() visited %d nodes before break, want 2", count)
+	}
+
+	count = 0
+	for range dom.Ancestors(dom.GetElementByID(doc, "b")) {
+		count++
+		break
+	}
+
+	if count != 1 {
+		t.Errorf("Ancestors() visited %d nodes before break, want 1", count)
+	}
+}
+
+func TestDescendantsWithOptions(t *testing.T) {
+	doc := generateDeepTree(100)
+
+	tests := []struct {
+		maxDepth int
+		divs     int
+		text     string
+	}{
+		{maxDepth: 0, divs: 100, text: "Deep text"},
+		{maxDepth: 1, divs: 1},
+		{maxDepth: 10, divs: 10},
+		{maxDepth: 101, divs: 100},
+		{maxDepth: 102, divs: 100, text: "Deep text"},
+	}
+
+	for _, tt := range tests {
+		var divs int
+		var text string
+		for node := range dom.DescendantsWithOptions(doc, dom.TraversalOptions{MaxDepth: tt.maxDepth}) {
+			switch node.Type {
+			case html.ElementNode:
+				if node.Data == "div" {
+					divs++
+				}
+			case html.TextNode:
+				text += node.Data
+			}
+		}
+
+		if divs != tt.divs || text != tt.text {
+			t.Errorf("DescendantsWithOptions() with max depth %d = %d divs and %q, want %d divs and %q",
+				tt.maxDepth, divs, text, tt.divs, tt.text)
+		}
+	}
+
+	// The limit doesn't affect the other functions
+	if got := len(dom.GetElementsByTagName(dom.Clone(doc, true), "div")); got != 100 {
+		t.Errorf("Clone() has %v divs, want 100", got)
+	}
+
+	if got := dom.TextContent(doc); got != "Deep text" {
+		t.Errorf("TextContent() = %q, want %q", got, "Deep text")
+	}
+}
@@ -0,0 +1,73 @@
+package dom
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/html"
+)
+
+// liveRegistry keeps track of the objects that must be notified when a
+// subtree is modified (e.g. Index and NodeIterator), grouped by the root
+// of the subtree they observe. A modification only looks up the objects
+// observing its ancestors, and costs nothing when there are no objects.
+//
+// The registry holds the objects strongly, so the public types wrap them
+// and unregister them with a finalizer once the wrapper is unreachable.
+type liveRegistry[T comparable] struct {
+	mu    sync.RWMutex
+	count atomic.Int64
+	roots map[*html.Node]map[T]struct{}
+}
+
+func (r *liveRegistry[T]) add(root *html.Node, item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.roots == nil {
+		r.roots = make(map[*html.Node]map[T]struct{})
+	}
+
+	items := r.roots[root]
+	if items == nil {
+		items = make(map[T]struct{})
+		r.roots[root] = items
+	}
+
+	if _, exist := items[item]; !exist {
+		items[item] = struct{}{}
+		r.count.Add(1)
+	}
+}
+
+func (r *liveRegistry[T]) remove(root *html.Node, item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.roots[root]
+	if _, exist := items[item]; !exist {
+		return
+	}
+
+	delete(items, item)
+	r.count.Add(-1)
+	if len(items) == 0 {
+		delete(r.roots, root)
+	}
+}
+
+// each calls fn for the objects whose root is node or one of its ancestors.
+func (r *liveRegistry[T]) each(node *html.Node, fn func(T)) {
+	if r.count.Load() == 0 {
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for ; node != nil; node = node.Parent {
+		for item := range r.roots[node] {
+			fn(item)
+		}
+	}
+}
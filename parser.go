@@ -1,13 +1,24 @@
 package dom
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
 
 	"github.com/gogs/chardet"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
 	xunicode "golang.org/x/text/encoding/unicode"
 	"golang.org/x/text/runes"
 	"golang.org/x/text/transform"
@@ -20,42 +31,806 @@ func FastParse(r io.Reader) (*html.Node, error) {
 	return html.Parse(r)
 }
 
+// FastParseWithOptions works like FastParse, but it also applies the limits
+// in the specified options. opts.ContentType is ignored since the input is
+// always treated as UTF-8. Like ParseWithOptions, a document exceeding
+// MaxNodes or MaxDepth is rejected before its tree is built, unless
+// opts.Truncate is set.
+func FastParseWithOptions(r io.Reader, opts ParseOptions) (*html.Node, error) {
+	if opts.MaxBytes > 0 {
+		r = &limitedReader{r: r, remaining: opts.MaxBytes, opts: opts}
+	}
+
+	doc, err := html.Parse(newTreeLimiter(r, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = limitTree(doc, opts); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// ParseFragment parses HTML fragment from the specified reader, as if it's
+// the content of contextNode, just like setting innerHTML in browser. This
+// way the content is parsed correctly for elements with special parsing
+// rules, e.g. <tr> inside <table>, <option> inside <select> or <path>
+// inside <svg>. If contextNode is nil or not an element, the fragment is
+// parsed as the content of <body>. Like FastParse, the input is assumed
+// to use UTF-8 encoding.
+func ParseFragment(r io.Reader, contextNode *html.Node) ([]*html.Node, error) {
+	return html.ParseFragment(r, fragmentContext(contextNode))
+}
+
+// fragmentContext returns the context element for parsing fragment inside
+// node. The parser refuses element whose DataAtom doesn't match its tag name,
+// which happens for element created manually (e.g. by CreateElement), so
+// a copy of node with the correct atom is used instead.
+func fragmentContext(node *html.Node) *html.Node {
+	if node == nil || node.Type != html.ElementNode {
+		return &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	}
+
+	tagName := node.Data
+	if node.Namespace == "" {
+		tagName = strings.ToLower(tagName)
+	}
+
+	return &html.Node{
+		Type:      html.ElementNode,
+		Data:      tagName,
+		DataAtom:  atom.Lookup([]byte(tagName)),
+		Namespace: node.Namespace,
+		Attr:      node.Attr,
+		Parent:    node.Parent,
+	}
+}
+
+// EncodingSource tells where the encoding used to decode a document comes from.
+type EncodingSource int
+
+const (
+	// EncodingSourceFallback means the encoding couldn't be determined,
+	// so the document is decoded as UTF-8.
+	EncodingSourceFallback EncodingSource = iota
+
+	// EncodingSourceBOM means the encoding is taken from the byte order mark.
+	EncodingSourceBOM
+
+	// EncodingSourceMeta means the encoding is declared by <meta> tag
+	// inside the document.
+	EncodingSourceMeta
+
+	// EncodingSourceDetector means the encoding is guessed by charset detector.
+	EncodingSourceDetector
+
+	// EncodingSourceTransport means the encoding is taken from the charset
+	// parameter of Content-Type header.
+	EncodingSourceTransport
+)
+
+// String returns the name of the encoding source.
+func (s EncodingSource) String() string {
+	switch s {
+	case EncodingSourceBOM:
+		return "bom"
+	case EncodingSourceMeta:
+		return "meta"
+	case EncodingSourceDetector:
+		return "detector"
+	case EncodingSourceTransport:
+		return "transport"
+	default:
+		return "fallback"
+	}
+}
+
+// ParseInfo contains the information about how a document is decoded.
+type ParseInfo struct {
+	// Charset is the WHATWG name of the encoding used to decode
+	// the document, e.g. "utf-8" or "shift_jis".
+	Charset string
+
+	// Confidence is the confidence of charset detector, from 1 to 100.
+	// It's 100 when the encoding is declared (by BOM, Content-Type or
+	// <meta> tag) and 0 when the fallback encoding is used.
+	Confidence int
+
+	// Source tells where the encoding comes from.
+	Source EncodingSource
+
+	// HasReplacement is true if the decoder had to replace invalid bytes
+	// with U+FFFD replacement character.
+	HasReplacement bool
+
+	// Truncated is true if part of the document is dropped because
+	// it exceeds the limits in ParseOptions.
+	Truncated bool
+}
+
+var (
+	// ErrTooLarge is returned when the document has more bytes or nodes
+	// than the limit specified in ParseOptions.
+	ErrTooLarge = errors.New("document is too large")
+
+	// ErrTooDeep is returned when the document tree is nested deeper
+	// than the limit specified in ParseOptions.
+	ErrTooDeep = errors.New("document is too deep")
+)
+
+// ParseOptions is the options for ParseWithOptions.
+type ParseOptions struct {
+	// ContentType is the value of Content-Type header which the document
+	// is served with, e.g. "text/html; charset=shift_jis". It's optional.
+	ContentType string
+
+	// MaxBytes is the maximum size of the document in bytes. If the document
+	// is larger, parsing fails with ErrTooLarge. Zero means unlimited.
+	MaxBytes int64
+
+	// MaxNodes is the maximum number of nodes in the parsed document,
+	// excluding the document node itself. If the document has more nodes,
+	// parsing fails with ErrTooLarge. Zero means unlimited.
+	//
+	// Unless Truncate is set, elements and comments are counted while the
+	// document is tokenized, so a document with too many of them is rejected
+	// before the parser builds the tree. Other nodes, e.g. text, are only
+	// counted in the parsed tree.
+	MaxNodes int
+
+	// MaxDepth is the maximum depth of the parsed document, where the
+	// children of the document node have depth 1. If the document is nested
+	// deeper, parsing fails with ErrTooDeep. Zero means unlimited. Like
+	// MaxNodes, it's checked while tokenizing unless Truncate is set.
+	MaxDepth int
+
+	// Truncate makes the parser drop the part of the document that exceeds
+	// the limits instead of failing. Bytes after MaxBytes are ignored, nodes
+	// after MaxNodes (in document order) are removed and so are nodes deeper
+	// than MaxDepth.
+	Truncate bool
+
+	// Normalize specifies how the text of the document is normalized.
+	// By default it's converted into NFC and soft hyphens are removed.
+	Normalize Normalization
+
+	// TrackPositions records the location of the tags of each element in
+	// the source, which then can be retrieved using SourcePosition(). The
+	// positions are kept until the document is garbage collected or they're
+	// released using ReleaseSourcePositions(). It makes parsing slower, so
+	// only enable it when it's needed. It's ignored by FastParseWithOptions,
+	// while ParseStream has to read the whole document like ParseWithOptions
+	// when it's enabled.
+	TrackPositions bool
+}
+
+// NormalizationForm is the Unicode normalization form which is applied
+// to the document while parsing.
+type NormalizationForm int
+
+const (
+	// NormalizeNFC converts the text into canonical composition, e.g.
+	// "e" followed by combining acute accent becomes "é". This is the default.
+	NormalizeNFC NormalizationForm = iota
+
+	// NormalizeNFKC converts the text into compatibility composition, which
+	// also folds compatibility characters, e.g. "ﬁ" becomes "fi" and
+	// full-width "Ａ" becomes "A".
+	NormalizeNFKC
+
+	// NormalizeNone leaves the text as it is.
+	NormalizeNone
+)
+
+// Normalization is the options for normalizing the text of the document.
+// The characters are removed before the normalization form is applied.
+// The zero value converts the text into NFC and removes soft hyphens.
+type Normalization struct {
+	// Form is the Unicode normalization form to apply.
+	Form NormalizationForm
+
+	// KeepSoftHyphen keeps soft hyphen (U+00AD), which by default is
+	// removed since apparently it's useless in web.
+	// See: https://web.archive.org/web/19990117011731/http://www.hut.fi/~jkorpela/shy.html
+	KeepSoftHyphen bool
+
+	// StripZeroWidth removes zero width space (U+200B), zero width
+	// non-joiner (U+200C), zero width joiner (U+200D) and word joiner
+	// (U+2060). Be careful, the joiners are meaningful in some scripts
+	// (e.g. Persian and Devanagari) and emoji sequences.
+	StripZeroWidth bool
+
+	// StripBOM removes byte order mark (U+FEFF) inside the document. The
+	// leading byte order mark is always removed while detecting encoding.
+	StripBOM bool
+
+	// StripBidiControls removes the bidirectional formatting characters,
+	// i.e. U+061C, U+200E, U+200F, U+202A to U+202E and U+2066 to U+2069.
+	StripBidiControls bool
+
+	// StripControls removes C0 control characters (U+0000 to U+001F),
+	// except tab, line feed, form feed and carriage return.
+	StripControls bool
+}
+
 // Parse parses html.Node from the specified reader while converting the character
 // encoding into UTF-8. This function is useful to correctly parse web pages that
 // uses custom text encoding, e.g. web pages from Asian websites. However, since it
 // has to detect charset before parsing, this function is quite slow and expensive
 // so if you sure the reader uses valid UTF-8, just use FastParse.
 func Parse(r io.Reader) (*html.Node, error) {
-	// Split the reader using tee
-	content, err := ioutil.ReadAll(r)
+	doc, _, err := ParseWithOptions(r, ParseOptions{})
+	return doc, err
+}
+
+// ParseWithInfo works like Parse, but it also returns the information
+// about the encoding that used to decode the document.
+func ParseWithInfo(r io.Reader) (*html.Node, *ParseInfo, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// ParseWithOptions works like ParseWithInfo with the specified options.
+//
+// The encoding is determined following the encoding sniffing algorithm in
+// the HTML specification: byte order mark, then the charset parameter of
+// Content-Type, then the <meta> tag within the first 1024 bytes. Only when
+// none of them exist the charset detector is used to guess the encoding.
+//
+// MaxBytes is enforced while reading, so it's the only limit which bounds
+// the memory used while parsing. Without Truncate, MaxNodes and MaxDepth
+// are also checked while tokenizing, so a document with too many or too
+// deeply nested elements is rejected before its tree is built.
+func ParseWithOptions(r io.Reader, opts ParseOptions) (*html.Node, *ParseInfo, error) {
+	return parseWithOptions(r, opts, nil)
+}
+
+// parseWithOptions is the implementation of ParseWithOptions. If diags is
+// not nil, the problems in the document are collected into it.
+func parseWithOptions(r io.Reader, opts ParseOptions, diags *diagnosticCollector) (*html.Node, *ParseInfo, error) {
+	content, truncated, err := readAll(r, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Detect page encoding
-	res, err := chardet.NewHtmlDetector().DetectBest(content)
+	bomSize := len(content)
+	pageEncoding, info, content := detectEncoding(content, opts.ContentType)
+	bomSize -= len(content)
+
+	// Decode the content using the page encoding while it's parsed. If
+	// positions are tracked, each start tag is marked so it can be found
+	// in the parsed tree, which needs the whole decoded content.
+	var (
+		decoded   io.Reader
+		positions []NodePosition
+	)
+
+	trackPositions := opts.TrackPositions || diags != nil
+	if trackPositions {
+		var marked []byte
+		marked, positions, err = markSourcePositions(pageEncoding, content, bomSize, diags)
+		if err != nil {
+			return nil, nil, err
+		}
+		decoded = bytes.NewReader(marked)
+	} else {
+		decoded = transform.NewReader(bytes.NewReader(content), pageEncoding.NewDecoder())
+	}
+
+	// Parse HTML from the decoded content, counting the replacement
+	// characters on the way
+	pattern, step := replacementPattern("utf-8")
+	replacements := newPatternCounter(decoded, pattern, step)
+
+	doc, err := html.Parse(newTreeLimiter(normalizeTextEncoding(replacements, opts.Normalize), opts))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	info.HasReplacement = replacements.count > countReplacementInSource(content, info.Charset)
 
-	pageEncoding, _ := charset.Lookup(res.Charset)
-	if pageEncoding == nil {
-		pageEncoding = xunicode.UTF8
+	pruned, err := limitTree(doc, opts)
+	if err != nil {
+		return nil, nil, err
 	}
+	info.Truncated = truncated || pruned
 
-	// Parse HTML using the page encoding
-	r = bytes.NewReader(content)
-	r = transform.NewReader(r, pageEncoding.NewDecoder())
-	r = normalizeTextEncoding(r)
-	return html.Parse(r)
+	if trackPositions {
+		nodes := registerSourcePositions(doc, positions, opts.TrackPositions)
+		if diags != nil {
+			diags.finish(doc, nodes, positions)
+		}
+	}
+
+	return doc, info, nil
+}
+
+// ParseResponse parses the body of HTTP response like ParseWithOptions.
+// The charset from Content-Type header of the response is used unless
+// opts.ContentType is specified. Body which is still compressed with gzip
+// or deflate is decompressed first, and opts.MaxBytes applies to the
+// decompressed body. The final URL of the request is recorded as the
+// document URL, see DocumentURL(). The response body is not closed.
+func ParseResponse(resp *http.Response, opts ParseOptions) (*html.Node, *ParseInfo, error) {
+	if opts.ContentType == "" {
+		opts.ContentType = resp.Header.Get("Content-Type")
+	}
+
+	body, err := decompressBody(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer body.Close()
+
+	doc, info, err := ParseWithOptions(body, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		SetDocumentURL(doc, resp.Request.URL)
+	}
+
+	return doc, info, nil
+}
+
+// decompressBody returns the body of the response, decompressed according
+// to its Content-Encoding. If the body is already decompressed (e.g. by
+// http.Transport) it's returned as it is. Closing the returned reader
+// releases the decompressor, but doesn't close the response body.
+func decompressBody(resp *http.Response) (io.ReadCloser, error) {
+	body := bufio.NewReader(resp.Body)
+	if resp.Uncompressed {
+		return io.NopCloser(body), nil
+	}
+
+	contentEncoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch contentEncoding {
+	case "", "identity":
+		return io.NopCloser(body), nil
+
+	case "gzip", "x-gzip":
+		// Some servers claim gzip while the body is not compressed
+		if magic, _ := body.Peek(2); len(magic) < 2 || magic[0] != 0x1F || magic[1] != 0x8B {
+			return io.NopCloser(body), nil
+		}
+		return gzip.NewReader(body)
+
+	case "deflate":
+		// Deflate is supposed to be zlib stream, but some servers
+		// send raw deflate instead.
+		header, _ := body.Peek(2)
+		if len(header) == 2 && header[0]&0x0F == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(body)
+		}
+		return flate.NewReader(body), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", contentEncoding)
+	}
+}
+
+// readAll reads r until EOF. If opts.MaxBytes is positive and r contains
+// more than that, it returns ErrTooLarge, or the first opts.MaxBytes bytes
+// if opts.Truncate is true. The returned bool tells whether the content
+// is truncated.
+func readAll(r io.Reader, opts ParseOptions) ([]byte, bool, error) {
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		content, err := ioutil.ReadAll(r)
+		return content, false, err
+	}
+
+	content, err := ioutil.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(content)) <= maxBytes {
+		return content, false, nil
+	}
+
+	if !opts.Truncate {
+		return nil, false, fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, maxBytes)
+	}
+
+	return content[:maxBytes], true, nil
+}
+
+// limitTree checks the number of nodes and the depth of doc against the
+// limits in opts. If opts.Truncate is true, the nodes which exceed the limits
+// are removed instead, and the returned bool tells whether any node is removed.
+func limitTree(doc *html.Node, opts ParseOptions) (bool, error) {
+	if opts.MaxNodes <= 0 && opts.MaxDepth <= 0 {
+		return false, nil
+	}
+
+	// next returns the node after n in document order, skipping
+	// its children, along with the depth of that node.
+	next := func(n *html.Node, depth int) (*html.Node, int) {
+		for n != doc && n.NextSibling == nil {
+			n = n.Parent
+			depth--
+		}
+
+		if n == doc {
+			return nil, 0
+		}
+		return n.NextSibling, depth
+	}
+
+	var (
+		count  int
+		pruned bool
+		node   = doc.FirstChild
+		depth  = 1
+	)
+
+	for node != nil {
+		var err error
+		switch {
+		case opts.MaxDepth > 0 && depth > opts.MaxDepth:
+			err = fmt.Errorf("%w: exceeds depth of %d", ErrTooDeep, opts.MaxDepth)
+		case opts.MaxNodes > 0 && count >= opts.MaxNodes:
+			err = fmt.Errorf("%w: exceeds %d nodes", ErrTooLarge, opts.MaxNodes)
+		}
+
+		if err != nil {
+			if !opts.Truncate {
+				return false, err
+			}
+
+			nextNode, nextDepth := next(node, depth)
+			node.Parent.RemoveChild(node)
+			node, depth = nextNode, nextDepth
+			pruned = true
+			continue
+		}
+
+		count++
+		if node.FirstChild != nil {
+			node, depth = node.FirstChild, depth+1
+		} else {
+			node, depth = next(node, depth)
+		}
+	}
+
+	return pruned, nil
+}
+
+// treeLimiter passes the document through to the parser while tokenizing
+// it, so a document which exceeds MaxNodes or MaxDepth is rejected before
+// the parser builds the whole tree. The open elements are tracked like the
+// parser does, but only the nodes and the depth that the parser certainly
+// creates are counted, so limitTree still has to check the parsed tree.
+type treeLimiter struct {
+	z        *html.Tokenizer
+	opts     ParseOptions
+	pending  []byte
+	err      error
+	openTags []openTag
+	nodes    int
+	frameset bool
+}
+
+// newTreeLimiter returns r wrapped in treeLimiter if there are limits to be
+// enforced. With opts.Truncate, the whole tree is needed to prune it, so
+// r is returned as it is.
+func newTreeLimiter(r io.Reader, opts ParseOptions) io.Reader {
+	if opts.Truncate || (opts.MaxNodes <= 0 && opts.MaxDepth <= 0) {
+		return r
+	}
+
+	// <html>, <head> and either <body> or <frameset> always exist
+	return &treeLimiter{z: html.NewTokenizer(r), opts: opts, nodes: 3}
+}
+
+func (l *treeLimiter) Read(p []byte) (int, error) {
+	for len(l.pending) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		l.next()
+	}
+
+	n := copy(p, l.pending)
+	l.pending = l.pending[n:]
+	return n, nil
+}
+
+// next reads the next token into pending, or the error into err.
+func (l *treeLimiter) next() {
+	z := l.z
+	z.AllowCDATA(len(l.openTags) > 0 && l.openTags[len(l.openTags)-1].namespace != "")
+
+	tokenType := z.Next()
+	if tokenType == html.ErrorToken {
+		l.pending, l.err = z.Buffered(), z.Err()
+		return
+	}
+	l.pending = z.Raw()
+
+	switch tokenType {
+	case html.CommentToken:
+		l.nodes++
+
+	case html.StartTagToken, html.SelfClosingTagToken:
+		tagName, hasAttr := z.TagName()
+		name := string(tagName)
+
+		var foreign bool
+		l.openTags, foreign = enterStartTag(l.openTags, name, z, hasAttr)
+		if l.isCounted(name, foreign) {
+			l.nodes++
+		}
+		l.frameset = l.frameset || (name == "frameset" && !foreign)
+
+		// Everything in frameset is ignored, except <frame> which is void
+		if !l.frameset {
+			tag := openTag{name: name}
+			l.openTags = pushStartTag(l.openTags, tag, foreign, tokenType == html.SelfClosingTagToken, z, hasAttr)
+		}
+
+	case html.EndTagToken:
+		tagName, _ := z.TagName()
+		for i := len(l.openTags) - 1; i >= 0; i-- {
+			if l.openTags[i].name == string(tagName) {
+				l.openTags = l.openTags[:i]
+				break
+			}
+		}
+	}
+
+	switch {
+	case l.opts.MaxDepth > 0 && openDepth(l.openTags) > l.opts.MaxDepth:
+		l.err = fmt.Errorf("%w: exceeds depth of %d", ErrTooDeep, l.opts.MaxDepth)
+	case l.opts.MaxNodes > 0 && l.nodes > l.opts.MaxNodes:
+		l.err = fmt.Errorf("%w: exceeds %d nodes", ErrTooLarge, l.opts.MaxNodes)
+	}
+
+	// The parser doesn't have to see the token which exceeds the limit
+	if l.err != nil {
+		l.pending = nil
+	}
+}
+
+// isCounted reports whether the start tag with the specified name certainly
+// creates an element. The parser ignores some tags depending on where they
+// are, e.g. <tr> outside of table, so those are never counted.
+func (l *treeLimiter) isCounted(name string, foreign bool) bool {
+	if foreign {
+		return true
+	}
+
+	_, ignorable := ignorableStartTags[name]
+	return !ignorable && !l.frameset && !isInSelect(l.openTags)
 }
 
-// normalizeTextEncoding convert text encoding from NFD to NFC.
-// It also remove soft hyphen since apparently it's useless in web.
-// See: https://web.archive.org/web/19990117011731/http://www.hut.fi/~jkorpela/shy.html
-func normalizeTextEncoding(r io.Reader) io.Reader {
-	fnSoftHyphen := func(r rune) bool { return r == '\u00AD' }
-	softHyphenSet := runes.Predicate(fnSoftHyphen)
-	transformer := transform.Chain(runes.Remove(softHyphenSet), norm.NFC)
-	return transform.NewReader(r, transformer)
+// detectEncoding determines the encoding of content. The returned
+// content has its byte order mark removed.
+func detectEncoding(content []byte, contentType string) (encoding.Encoding, *ParseInfo, []byte) {
+	// Check byte order mark
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(content, bom.mark) {
+			enc, name := charset.Lookup(bom.charset)
+			info := &ParseInfo{Charset: name, Confidence: 100, Source: EncodingSourceBOM}
+			return enc, info, content[len(bom.mark):]
+		}
+	}
+
+	// Check the charset from transport layer
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		if enc, name := charset.Lookup(params["charset"]); enc != nil {
+			info := &ParseInfo{Charset: name, Confidence: 100, Source: EncodingSourceTransport}
+			return enc, info, content
+		}
+	}
+
+	// Look for <meta> which declares the encoding
+	if enc, name := prescanEncoding(content); enc != nil {
+		info := &ParseInfo{Charset: name, Confidence: 100, Source: EncodingSourceMeta}
+		return enc, info, content
+	}
+
+	// Guess the encoding with charset detector
+	if len(content) > 0 {
+		if res, err := chardet.NewHtmlDetector().DetectBest(content); err == nil {
+			if enc, name := charset.Lookup(res.Charset); enc != nil {
+				info := &ParseInfo{Charset: name, Confidence: res.Confidence, Source: EncodingSourceDetector}
+				return enc, info, content
+			}
+		}
+	}
+
+	return xunicode.UTF8, &ParseInfo{Charset: "utf-8", Source: EncodingSourceFallback}, content
+}
+
+// prescanEncoding looks for <meta> tag which declares the encoding within
+// the first 1024 bytes of content, following the prescan algorithm in the
+// HTML specification.
+func prescanEncoding(content []byte) (encoding.Encoding, string) {
+	if len(content) > 1024 {
+		content = content[:1024]
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return nil, ""
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tagName, hasAttr := z.TagName()
+			if string(tagName) != "meta" {
+				continue
+			}
+
+			var (
+				label     string
+				isPragma  bool
+				fromAttr  bool
+				seenAttrs = map[string]bool{}
+			)
+
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				if seenAttrs[string(key)] {
+					continue
+				}
+				seenAttrs[string(key)] = true
+
+				switch string(key) {
+				case "http-equiv":
+					isPragma = strings.EqualFold(string(val), "content-type")
+				case "content":
+					if label == "" {
+						label = charsetFromMetaContent(string(val))
+					}
+				case "charset":
+					label, fromAttr = string(val), true
+				}
+			}
+
+			// Charset inside content attribute only counts
+			// when http-equiv is content-type.
+			if label == "" || (!fromAttr && !isPragma) {
+				continue
+			}
+
+			enc, name := charset.Lookup(label)
+			switch {
+			case enc == nil:
+				continue
+			case strings.HasPrefix(name, "utf-16"):
+				enc, name = charset.Lookup("utf-8")
+			case name == "x-user-defined":
+				enc, name = charset.Lookup("windows-1252")
+			}
+			return enc, name
+		}
+	}
+}
+
+// charsetFromMetaContent extracts the charset from the content
+// attribute of <meta>, e.g. "text/html; charset=utf-8".
+func charsetFromMetaContent(content string) string {
+	lower := strings.ToLower(content)
+	for {
+		idx := strings.Index(lower, "charset")
+		if idx < 0 {
+			return ""
+		}
+
+		lower = strings.TrimLeft(lower[idx+len("charset"):], " \t\n\f\r")
+		if !strings.HasPrefix(lower, "=") {
+			continue
+		}
+
+		lower = strings.TrimLeft(lower[1:], " \t\n\f\r")
+		if lower == "" {
+			return ""
+		}
+
+		if quote := lower[0]; quote == '"' || quote == '\'' {
+			end := strings.IndexByte(lower[1:], quote)
+			if end < 0 {
+				return ""
+			}
+			return lower[1 : end+1]
+		}
+
+		if end := strings.IndexAny(lower, " \t\n\f\r;"); end >= 0 {
+			return lower[:end]
+		}
+		return lower
+	}
+}
+
+var byteOrderMarks = []struct {
+	mark    []byte
+	charset string
+}{
+	{[]byte{0xEF, 0xBB, 0xBF}, "utf-8"},
+	{[]byte{0xFE, 0xFF}, "utf-16be"},
+	{[]byte{0xFF, 0xFE}, "utf-16le"},
+}
+
+// countReplacementInSource counts U+FFFD replacement characters which
+// already exist in the source, before it's decoded.
+func countReplacementInSource(content []byte, charsetName string) int {
+	pattern, step := replacementPattern(charsetName)
+	if pattern == nil {
+		return 0
+	}
+
+	count := 0
+	for i := 0; i+len(pattern) <= len(content); i += step {
+		if bytes.Equal(content[i:i+len(pattern)], pattern) {
+			count++
+		}
+	}
+	return count
+}
+
+// replacementPattern returns the bytes of U+FFFD replacement character
+// in the specified charset along with its code unit size, or nil if
+// it's not counted in that charset.
+func replacementPattern(charsetName string) ([]byte, int) {
+	switch charsetName {
+	case "utf-8":
+		return []byte("\uFFFD"), 1
+	case "utf-16le":
+		return []byte{0xFD, 0xFF}, 2
+	case "utf-16be":
+		return []byte{0xFF, 0xFD}, 2
+	default:
+		return nil, 1
+	}
+}
+
+// normalizeTextEncoding removes the characters and applies the Unicode
+// normalization form as specified in n.
+func normalizeTextEncoding(r io.Reader, n Normalization) io.Reader {
+	var transformers []transform.Transformer
+	if n.hasRemoval() {
+		transformers = append(transformers, runes.Remove(runes.Predicate(n.isRemoved)))
+	}
+
+	switch n.Form {
+	case NormalizeNFC:
+		transformers = append(transformers, norm.NFC)
+	case NormalizeNFKC:
+		transformers = append(transformers, norm.NFKC)
+	}
+
+	if len(transformers) == 0 {
+		return r
+	}
+	return transform.NewReader(r, transform.Chain(transformers...))
+}
+
+func (n Normalization) hasRemoval() bool {
+	return !n.KeepSoftHyphen || n.StripZeroWidth || n.StripBOM ||
+		n.StripBidiControls || n.StripControls
+}
+
+// isRemoved reports whether r should be removed from the document.
+func (n Normalization) isRemoved(r rune) bool {
+	switch {
+	case r == '\u00AD':
+		return !n.KeepSoftHyphen
+	case r == '\uFEFF':
+		return n.StripBOM
+	case r == '\u200B', r == '\u200C', r == '\u200D', r == '\u2060':
+		return n.StripZeroWidth
+	case r == '\u061C', r == '\u200E', r == '\u200F',
+		r >= '\u202A' && r <= '\u202E', r >= '\u2066' && r <= '\u2069':
+		return n.StripBidiControls
+	case r < 0x20 && r != '\t' && r != '\n' && r != '\f' && r != '\r':
+		return n.StripControls
+	default:
+		return false
+	}
 }
@@ -0,0 +1,791 @@
+package dom_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const japaneseText = `吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。` +
+	`何でも薄暗いじめじめした所でニャーニャー泣いていた事だけは記憶している。` +
+	`吾輩はここで始めて人間というものを見た。しかもあとで聞くとそれは書生という` +
+	`人間中で一番獰悪な種族であったそうだ。`
+
+const russianText = `Съешь же ещё этих мягких французских булок, да выпей чаю. ` +
+	`В чащах юга жил бы цитрус? Да, но фальшивый экземпляр! ` +
+	`Широкая электрификация южных губерний даст мощный толчок подъёму сельского хозяйства.`
+
+func encodeString(t *testing.T, enc encoding.Encoding, s string) []byte {
+	encoded, err := enc.NewEncoder().String(s)
+	if err != nil {
+		t.Fatalf("failed to encode: %v", err)
+	}
+	return []byte(encoded)
+}
+
+func TestParseWithInfo(t *testing.T) {
+	utf8BOM := []byte{0xEF, 0xBB, 0xBF}
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+
+	tests := []struct {
+		name           string
+		content        []byte
+		text           string
+		charset        string
+		source         dom.EncodingSource
+		hasReplacement bool
+	}{{
+		name:    "utf-8 with bom",
+		content: append(utf8BOM, "<p>Héllo wörld</p>"...),
+		text:    "Héllo wörld",
+		charset: "utf-8",
+		source:  dom.EncodingSourceBOM,
+	}, {
+		name:    "utf-16le with bom",
+		content: encodeString(t, utf16LE, "<p>Héllo wörld</p>"),
+		text:    "Héllo wörld",
+		charset: "utf-16le",
+		source:  dom.EncodingSourceBOM,
+	}, {
+		name:    "shift_jis",
+		content: encodeString(t, japanese.ShiftJIS, "<html><body><p>"+japaneseText+"</p></body></html>"),
+		text:    japaneseText,
+		charset: "shift_jis",
+		source:  dom.EncodingSourceDetector,
+	}, {
+		name:    "windows-1251",
+		content: encodeString(t, charmap.Windows1251, "<html><body><p>"+russianText+"</p></body></html>"),
+		text:    russianText,
+		charset: "windows-1251",
+		source:  dom.EncodingSourceDetector,
+	}, {
+		name:           "invalid utf-8",
+		content:        append(utf8BOM, "<p>Broken \xff\xfe text</p>"...),
+		text:           "Broken �� text",
+		charset:        "utf-8",
+		source:         dom.EncodingSourceBOM,
+		hasReplacement: true,
+	}, {
+		name:    "existing replacement character",
+		content: append(utf8BOM, "<p>Already � here</p>"...),
+		text:    "Already � here",
+		charset: "utf-8",
+		source:  dom.EncodingSourceBOM,
+	}, {
+		name:    "empty",
+		content: nil,
+		charset: "utf-8",
+		source:  dom.EncodingSourceFallback,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, info, err := dom.ParseWithInfo(bytes.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("ParseWithInfo() error = %v", err)
+			}
+
+			if got := strings.TrimSpace(dom.TextContent(doc)); got != tt.text {
+				t.Errorf("ParseWithInfo() text = %q, want %q", got, tt.text)
+			}
+
+			if info.Charset != tt.charset {
+				t.Errorf("ParseInfo.Charset = %q, want %q", info.Charset, tt.charset)
+			}
+
+			if info.Source != tt.source {
+				t.Errorf("ParseInfo.Source = %v, want %v", info.Source, tt.source)
+			}
+
+			if info.HasReplacement != tt.hasReplacement {
+				t.Errorf("ParseInfo.HasReplacement = %v, want %v", info.HasReplacement, tt.hasReplacement)
+			}
+
+			if tt.source == dom.EncodingSourceBOM && info.Confidence != 100 {
+				t.Errorf("ParseInfo.Confidence = %v, want %v", info.Confidence, 100)
+			}
+
+			if tt.source == dom.EncodingSourceDetector && (info.Confidence < 1 || info.Confidence > 100) {
+				t.Errorf("ParseInfo.Confidence = %v, want between 1 and 100", info.Confidence)
+			}
+		})
+	}
+}
+
+func TestParseWithOptions(t *testing.T) {
+	shortJapanese := "<p>吾輩は猫である</p>"
+	shortRussian := "<p>Привет, мир</p>"
+	padding := "<!--" + strings.Repeat("x", 1024) + "-->"
+
+	tests := []struct {
+		name        string
+		content     []byte
+		contentType string
+		text        string
+		charset     string
+		source      dom.EncodingSource
+	}{{
+		name:        "transport header",
+		content:     encodeString(t, japanese.ShiftJIS, shortJapanese),
+		contentType: "text/html; charset=Shift_JIS",
+		text:        "吾輩は猫である",
+		charset:     "shift_jis",
+		source:      dom.EncodingSourceTransport,
+	}, {
+		name:        "meta charset",
+		content:     encodeString(t, charmap.Windows1251, `<meta charset="windows-1251">`+shortRussian),
+		contentType: "text/html",
+		text:        "Привет, мир",
+		charset:     "windows-1251",
+		source:      dom.EncodingSourceMeta,
+	}, {
+		name:    "meta http-equiv",
+		content: encodeString(t, japanese.ShiftJIS, `<meta http-equiv="Content-Type" content="text/html; charset='sjis'">`+shortJapanese),
+		text:    "吾輩は猫である",
+		charset: "shift_jis",
+		source:  dom.EncodingSourceMeta,
+	}, {
+		name:    "meta content without http-equiv",
+		content: []byte(`<meta name="x" content="charset=windows-1251"><p>Hello</p>`),
+		text:    "Hello",
+		source:  dom.EncodingSourceDetector,
+	}, {
+		name:    "meta utf-16 is treated as utf-8",
+		content: []byte(`<meta charset="utf-16"><p>Héllo</p>`),
+		text:    "Héllo",
+		charset: "utf-8",
+		source:  dom.EncodingSourceMeta,
+	}, {
+		name:    "meta after 1024 bytes",
+		content: encodeString(t, charmap.Windows1251, padding+`<meta charset="windows-1251"><p>Hello</p>`),
+		text:    "Hello",
+		source:  dom.EncodingSourceDetector,
+	}, {
+		name:        "header beats meta",
+		content:     encodeString(t, charmap.Windows1251, `<meta charset="shift_jis">`+shortRussian),
+		contentType: `text/html; charset="windows-1251"`,
+		text:        "Привет, мир",
+		charset:     "windows-1251",
+		source:      dom.EncodingSourceTransport,
+	}, {
+		name:        "bom beats header",
+		content:     append([]byte{0xEF, 0xBB, 0xBF}, shortRussian...),
+		contentType: "text/html; charset=windows-1251",
+		text:        "Привет, мир",
+		charset:     "utf-8",
+		source:      dom.EncodingSourceBOM,
+	}, {
+		name:        "unknown charset in header",
+		content:     encodeString(t, charmap.Windows1251, `<meta charset="windows-1251">`+shortRussian),
+		contentType: "text/html; charset=nonsense",
+		text:        "Привет, мир",
+		charset:     "windows-1251",
+		source:      dom.EncodingSourceMeta,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := dom.ParseOptions{ContentType: tt.contentType}
+			doc, info, err := dom.ParseWithOptions(bytes.NewReader(tt.content), opts)
+			if err != nil {
+				t.Fatalf("ParseWithOptions() error = %v", err)
+			}
+
+			if got := strings.TrimSpace(dom.TextContent(doc)); got != tt.text {
+				t.Errorf("ParseWithOptions() text = %q, want %q", got, tt.text)
+			}
+
+			if tt.charset != "" && info.Charset != tt.charset {
+				t.Errorf("ParseInfo.Charset = %q, want %q", info.Charset, tt.charset)
+			}
+
+			if info.Source != tt.source {
+				t.Errorf("ParseInfo.Source = %v, want %v", info.Source, tt.source)
+			}
+		})
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	zlibbed := func(s string) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name            string
+		body            []byte
+		contentType     string
+		contentEncoding string
+		uncompressed    bool
+		maxBytes        int64
+		text            string
+		err             error
+	}{{
+		name:        "charset from header",
+		body:        encodeString(t, japanese.ShiftJIS, "<p>吾輩は猫である</p>"),
+		contentType: "text/html; charset=shift_jis",
+		text:        "吾輩は猫である",
+	}, {
+		name:            "gzip",
+		body:            gzipped("<p>Compressed</p>"),
+		contentEncoding: "gzip",
+		text:            "Compressed",
+	}, {
+		name:            "gzip header but plain body",
+		body:            []byte("<p>Plain</p>"),
+		contentEncoding: "gzip",
+		text:            "Plain",
+	}, {
+		name:            "already decompressed",
+		body:            []byte("<p>Decompressed</p>"),
+		contentEncoding: "gzip",
+		uncompressed:    true,
+		text:            "Decompressed",
+	}, {
+		name:            "deflate",
+		body:            zlibbed("<p>Deflated</p>"),
+		contentEncoding: "deflate",
+		text:            "Deflated",
+	}, {
+		name:            "unsupported encoding",
+		body:            []byte("<p>Brotli</p>"),
+		contentEncoding: "br",
+		err:             errors.New("unsupported content encoding"),
+	}, {
+		name:     "within limit",
+		body:     []byte("<p>Small</p>"),
+		maxBytes: 12,
+		text:     "Small",
+	}, {
+		name:     "too large",
+		body:     []byte("<p>Too large</p>"),
+		maxBytes: 12,
+		err:      dom.ErrTooLarge,
+	}, {
+		name:            "limit applies to decompressed body",
+		body:            gzipped("<p>" + strings.Repeat("a", 1000) + "</p>"),
+		contentEncoding: "gzip",
+		maxBytes:        500,
+		err:             dom.ErrTooLarge,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				Header:       http.Header{},
+				Body:         ioutil.NopCloser(bytes.NewReader(tt.body)),
+				Uncompressed: tt.uncompressed,
+			}
+			resp.Header.Set("Content-Type", tt.contentType)
+			resp.Header.Set("Content-Encoding", tt.contentEncoding)
+
+			doc, _, err := dom.ParseResponse(resp, dom.ParseOptions{MaxBytes: tt.maxBytes})
+			if tt.err != nil {
+				if err == nil || (!errors.Is(err, tt.err) && !strings.Contains(err.Error(), tt.err.Error())) {
+					t.Fatalf("ParseResponse() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+
+			if got := strings.TrimSpace(dom.TextContent(doc)); got != tt.text {
+				t.Errorf("ParseResponse() text = %q, want %q", got, tt.text)
+			}
+		})
+	}
+}
+
+func TestParseResponseURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/articles/new?id=1", http.StatusFound)
+	})
+	mux.HandleFunc("/articles/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head></head><body><a href="image.png">img</a></body></html>`))
+	})
+	mux.HandleFunc("/with-base", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><head><base href="/static/"></head><body></body></html>`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path        string
+		documentURL string
+		baseURL     string
+	}{
+		{"/old", server.URL + "/articles/new?id=1", server.URL + "/articles/new?id=1"},
+		{"/with-base", server.URL + "/with-base", server.URL + "/static/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+			defer resp.Body.Close()
+
+			doc, _, err := dom.ParseResponse(resp, dom.ParseOptions{})
+			if err != nil {
+				t.Fatalf("ParseResponse() error = %v", err)
+			}
+
+			if got := dom.DocumentURL(doc); got == nil || got.String() != tt.documentURL {
+				t.Errorf("DocumentURL() = %v, want %v", got, tt.documentURL)
+			}
+
+			// Base URL is available from any node in the document
+			body := dom.QuerySelector(doc, "body")
+			if got := dom.BaseURL(body); got == nil || got.String() != tt.baseURL {
+				t.Errorf("BaseURL() = %v, want %v", got, tt.baseURL)
+			}
+
+			// Document URL is not rendered
+			if strings.Contains(dom.OuterHTML(doc), server.URL) {
+				t.Errorf("OuterHTML() contains document URL: %s", dom.OuterHTML(doc))
+			}
+
+			// Document URL is not kept in the tree
+			if len(doc.Attr) != 0 {
+				t.Errorf("document attributes = %v, want empty", doc.Attr)
+			}
+
+			if got := dom.DocumentURL(dom.Clone(doc, true)); got != nil {
+				t.Errorf("DocumentURL() of clone = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestDocumentURLWithoutRelease(t *testing.T) {
+	collected := make(chan struct{})
+	func() {
+		doc, err := dom.FastParse(strings.NewReader("<p>Text</p>"))
+		if err != nil {
+			t.Fatalf("failed to parse: %v", err)
+		}
+
+		dom.SetDocumentURL(doc, &url.URL{Scheme: "https", Host: "example.com"})
+		runtime.AddCleanup(doc, func(ch chan struct{}) { close(ch) }, collected)
+	}()
+
+	// Document URL doesn't keep its document alive
+	if !waitCollected(collected) {
+		t.Errorf("document with URL is not garbage collected")
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	deep := strings.Repeat("<div>", 50) + "deep" + strings.Repeat("</div>", 50)
+	many := "<p>1</p><p>2</p><p>3</p><p>4</p><p>5</p>"
+
+	tests := []struct {
+		name      string
+		source    string
+		opts      dom.ParseOptions
+		err       error
+		truncated bool
+		text      string
+	}{{
+		name:   "within limits",
+		source: many,
+		opts:   dom.ParseOptions{MaxBytes: 100, MaxNodes: 100, MaxDepth: 10},
+		text:   "12345",
+	}, {
+		name:   "too many bytes",
+		source: many,
+		opts:   dom.ParseOptions{MaxBytes: 20},
+		err:    dom.ErrTooLarge,
+	}, {
+		name:      "truncate bytes",
+		source:    many,
+		opts:      dom.ParseOptions{MaxBytes: 16, Truncate: true},
+		truncated: true,
+		text:      "12",
+	}, {
+		// html, head, body, then p and its text for each paragraph
+		name:   "too many nodes",
+		source: many,
+		opts:   dom.ParseOptions{MaxNodes: 8},
+		err:    dom.ErrTooLarge,
+	}, {
+		name:      "truncate nodes",
+		source:    many,
+		opts:      dom.ParseOptions{MaxNodes: 8, Truncate: true},
+		truncated: true,
+		text:      "12",
+	}, {
+		name:   "exact node count",
+		source: many,
+		opts:   dom.ParseOptions{MaxNodes: 13},
+		text:   "12345",
+	}, {
+		name:   "too deep",
+		source: deep,
+		opts:   dom.ParseOptions{MaxDepth: 20},
+		err:    dom.ErrTooDeep,
+	}, {
+		name:      "truncate depth",
+		source:    "<div>shallow" + deep + "</div><p>after</p>",
+		opts:      dom.ParseOptions{MaxDepth: 20, Truncate: true},
+		truncated: true,
+		text:      "shallowafter",
+	}}
+
+	parsers := map[string]func(string, dom.ParseOptions) (*html.Node, *dom.ParseInfo, error){
+		"Parse": func(s string, opts dom.ParseOptions) (*html.Node, *dom.ParseInfo, error) {
+			return dom.ParseWithOptions(strings.NewReader(s), opts)
+		},
+		"FastParse": func(s string, opts dom.ParseOptions) (*html.Node, *dom.ParseInfo, error) {
+			doc, err := dom.FastParseWithOptions(strings.NewReader(s), opts)
+			return doc, nil, err
+		},
+	}
+
+	for parserName, parse := range parsers {
+		for _, tt := range tests {
+			t.Run(parserName+"/"+tt.name, func(t *testing.T) {
+				doc, info, err := parse(tt.source, tt.opts)
+				if tt.err != nil {
+					if !errors.Is(err, tt.err) {
+						t.Fatalf("%s() error = %v, want %v", parserName, err, tt.err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("%s() error = %v", parserName, err)
+				}
+
+				if got := dom.TextContent(doc); got != tt.text {
+					t.Errorf("%s() text = %q, want %q", parserName, got, tt.text)
+				}
+
+				if info != nil && info.Truncated != tt.truncated {
+					t.Errorf("%s() Truncated = %v, want %v", parserName, info.Truncated, tt.truncated)
+				}
+			})
+		}
+	}
+}
+
+// treeSize returns the number of nodes in doc, excluding doc itself,
+// and the depth of its deepest node.
+func treeSize(doc *html.Node) (int, int) {
+	var count, maxDepth int
+	for node := range dom.Descendants(doc) {
+		depth := 0
+		for n := node; n != doc; n = n.Parent {
+			depth++
+		}
+
+		count++
+		maxDepth = max(maxDepth, depth)
+	}
+	return count, maxDepth
+}
+
+func TestParseLimitsExact(t *testing.T) {
+	// Documents which the parser repairs, so the tree is quite different
+	// with the tags in the source.
+	sources := []string{
+		"<p>One<p>Two<div>Three</div></p>",
+		"<ul><li>One<li>Two<li><ul><li>Nested</ul></ul><dl><dt>A<dd>B<dt>C</dl>",
+		"<table><div>Moved<div>Again</div></div><tr><td>A<td>B<tr><td><table><tr><td>In</table></table>",
+		"<td>Cell<td>Cell<tr><tr><caption>x<col><tbody>Outside table",
+		"<select><div><div><option>A<option>B<optgroup><option>C</select>",
+		"<a><a><a>Link</a></a></a><nobr><nobr>x</nobr><button><button>y",
+		"<h1><h2><h3>Heading</h3></h2></h1><form><form><input></form></form>",
+		"<b><i><div>Misnested</b></i>text</div><p><b>bold<div>block</div></b></p>",
+		"<svg><![CDATA[ <div><div><div> ]]><title><b>x</b></title><g><g><p>Out</svg>",
+		"<math><mi><div>x</div></mi><annotation-xml encoding=\"text/html\"><p>y</annotation-xml></math>",
+		"<html><head><title>T</title></head><frameset><frame><div><div></frameset></html>",
+		"<!-- comment --><html><body><!-- inside --></body></html><!-- after -->",
+	}
+
+	parsers := map[string]func(string, dom.ParseOptions) (*html.Node, error){
+		"Parse": func(s string, opts dom.ParseOptions) (*html.Node, error) {
+			doc, _, err := dom.ParseWithOptions(strings.NewReader(s), opts)
+			return doc, err
+		},
+		"FastParse": func(s string, opts dom.ParseOptions) (*html.Node, error) {
+			return dom.FastParseWithOptions(strings.NewReader(s), opts)
+		},
+		"ParseStream": func(s string, opts dom.ParseOptions) (*html.Node, error) {
+			doc, _, err := dom.ParseStream(strings.NewReader(s), opts)
+			return doc, err
+		},
+	}
+
+	for _, source := range sources {
+		doc, err := dom.FastParse(strings.NewReader(source))
+		if err != nil {
+			t.Fatalf("FastParse() error = %v", err)
+		}
+
+		// Documents that fit exactly into the limits must not be
+		// rejected early, while the slightly smaller limits must fail.
+		nodes, depth := treeSize(doc)
+		for parserName, parse := range parsers {
+			if _, err := parse(source, dom.ParseOptions{MaxNodes: nodes, MaxDepth: depth}); err != nil {
+				t.Errorf("%s(%q) error = %v, want nil", parserName, source, err)
+			}
+
+			if _, err := parse(source, dom.ParseOptions{MaxNodes: nodes - 1}); !errors.Is(err, dom.ErrTooLarge) {
+				t.Errorf("%s(%q) with %d nodes error = %v, want %v", parserName, source, nodes-1, err, dom.ErrTooLarge)
+			}
+
+			if _, err := parse(source, dom.ParseOptions{MaxDepth: depth - 1}); !errors.Is(err, dom.ErrTooDeep) {
+				t.Errorf("%s(%q) with depth %d error = %v, want %v", parserName, source, depth-1, err, dom.ErrTooDeep)
+			}
+		}
+	}
+}
+
+// countingReader counts the bytes which are read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestParseLimitsEarly(t *testing.T) {
+	const size = 1 << 20
+	tests := []struct {
+		name   string
+		source string
+		opts   dom.ParseOptions
+		err    error
+	}{
+		{"too many nodes", strings.Repeat("<p>x</p>", size/8), dom.ParseOptions{MaxNodes: 1000}, dom.ErrTooLarge},
+		{"too deep", strings.Repeat("<div>", size/5), dom.ParseOptions{MaxDepth: 100}, dom.ErrTooDeep},
+		{"too deep formatting", strings.Repeat("<span><b>", size/9), dom.ParseOptions{MaxDepth: 100}, dom.ErrTooDeep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The document is rejected long before it's read completely
+			r := &countingReader{r: strings.NewReader(tt.source)}
+			_, _, err := dom.ParseStream(r, tt.opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseStream() error = %v, want %v", err, tt.err)
+			}
+
+			if r.n >= len(tt.source)/2 {
+				t.Errorf("ParseStream() read %d of %d bytes before failing", r.n, len(tt.source))
+			}
+		})
+	}
+}
+
+func TestParseFragment(t *testing.T) {
+	tests := []struct {
+		name    string
+		context string
+		source  string
+		want    string
+	}{{
+		name:   "without context",
+		source: "<p>Hello</p><tr><td>Cell</td></tr>",
+		want:   "<p>Hello</p>Cell",
+	}, {
+		name:    "table",
+		context: "table",
+		source:  "<tr><td>Cell</td></tr>",
+		want:    "<tbody><tr><td>Cell</td></tr></tbody>",
+	}, {
+		name:    "tbody",
+		context: "tbody",
+		source:  "<tr><td>A</td></tr><tr><td>B</td></tr>",
+		want:    "<tr><td>A</td></tr><tr><td>B</td></tr>",
+	}, {
+		name:    "tr",
+		context: "tr",
+		source:  "<td>A</td><th>B</th>",
+		want:    "<td>A</td><th>B</th>",
+	}, {
+		name:    "select",
+		context: "select",
+		source:  "<option>One</option><optgroup><option>Two</option></optgroup><div>Three</div>",
+		want:    "<option>One</option><optgroup><option>Two</option></optgroup>Three",
+	}, {
+		name:    "template",
+		context: "template",
+		source:  "<tr><td>Cell</td></tr>",
+		want:    "<tr><td>Cell</td></tr>",
+	}, {
+		name:    "svg",
+		context: "svg",
+		source:  `<path d="M0 0"/><circle r="1"></circle>`,
+		want:    `<path d="M0 0"></path><circle r="1"></circle>`,
+	}, {
+		name:    "textarea",
+		context: "textarea",
+		source:  "<b>not bold</b>",
+		want:    "&lt;b&gt;not bold&lt;/b&gt;",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var context *html.Node
+			if tt.context != "" {
+				context = dom.CreateElement(tt.context)
+				if tt.context == "svg" {
+					context.Namespace = "svg"
+				}
+			}
+
+			nodes, err := dom.ParseFragment(strings.NewReader(tt.source), context)
+			if err != nil {
+				t.Fatalf("ParseFragment() error = %v", err)
+			}
+
+			var sb strings.Builder
+			for _, node := range nodes {
+				if node.Parent != nil {
+					t.Errorf("ParseFragment() returns node with parent: %s", dom.OuterHTML(node))
+				}
+				sb.WriteString(dom.OuterHTML(node))
+			}
+
+			if got := sb.String(); got != tt.want {
+				t.Errorf("ParseFragment() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNormalization(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		normalize dom.Normalization
+		want      string
+	}{{
+		name: "default nfc",
+		text: "Cafe\u0301 ga\u0300\u0301 ｶﾞ ﬁ",
+		want: "Café gà\u0301 ｶﾞ ﬁ",
+	}, {
+		name:      "nfkc",
+		text:      "Cafe\u0301 ｶﾞ ﬁ Ｗｉｄｅ ①",
+		normalize: dom.Normalization{Form: dom.NormalizeNFKC},
+		want:      "Café ガ fi Wide 1",
+	}, {
+		name:      "no normalization form",
+		text:      "Cafe\u0301 ｶﾞ",
+		normalize: dom.Normalization{Form: dom.NormalizeNone},
+		want:      "Cafe\u0301 ｶﾞ",
+	}, {
+		name: "default removes soft hyphen",
+		text: "Донау\u00ADдампф\u00ADшифф",
+		want: "Донаудампфшифф",
+	}, {
+		name:      "keep soft hyphen",
+		text:      "Донау\u00ADдампф\u00ADшифф",
+		normalize: dom.Normalization{KeepSoftHyphen: true},
+		want:      "Донау\u00ADдампф\u00ADшифф",
+	}, {
+		name: "default keeps zero width",
+		text: "吾輩は\u200B猫である。می\u200Cخواهم",
+		want: "吾輩は\u200B猫である。می\u200Cخواهم",
+	}, {
+		name:      "strip zero width",
+		text:      "吾輩は\u200B猫である。می\u200Cخواهم 👩\u200D💻 a\u2060b",
+		normalize: dom.Normalization{StripZeroWidth: true},
+		want:      "吾輩は猫である。میخواهم 👩💻 ab",
+	}, {
+		name:      "strip bom",
+		text:      "Привет\uFEFFмир",
+		normalize: dom.Normalization{StripBOM: true},
+		want:      "Приветмир",
+	}, {
+		name:      "strip bidi controls",
+		text:      "\u202Bשלום\u202C \u200Fمرحبا\u200E \u2067abc\u2069 \u061C١",
+		normalize: dom.Normalization{StripBidiControls: true},
+		want:      "שלום مرحبا abc ١",
+	}, {
+		name:      "strip controls",
+		text:      "Hello\x01 wörld\x1B\tend",
+		normalize: dom.Normalization{StripControls: true},
+		want:      "Hello wörld\tend",
+	}, {
+		name: "default keeps controls",
+		text: "Hello\x01 wörld\x1B\tend",
+		want: "Hello\x01 wörld\x1B\tend",
+	}, {
+		name: "everything",
+		text: "Cafe\u0301\u00AD\u200B\uFEFF\u200F\x07 ﬁ",
+		normalize: dom.Normalization{
+			Form:              dom.NormalizeNFKC,
+			StripZeroWidth:    true,
+			StripBOM:          true,
+			StripBidiControls: true,
+			StripControls:     true,
+		},
+		want: "Café fi",
+	}}
+
+	parsers := map[string]func(io.Reader, dom.ParseOptions) (*html.Node, *dom.ParseInfo, error){
+		"ParseWithOptions": dom.ParseWithOptions,
+		"ParseStream":      dom.ParseStream,
+	}
+
+	for parserName, parse := range parsers {
+		for _, tt := range tests {
+			t.Run(parserName+"/"+tt.name, func(t *testing.T) {
+				opts := dom.ParseOptions{
+					ContentType: "text/html; charset=utf-8",
+					Normalize:   tt.normalize,
+				}
+
+				doc, _, err := parse(strings.NewReader("<p>"+tt.text+"</p>"), opts)
+				if err != nil {
+					t.Fatalf("%s() error = %v", parserName, err)
+				}
+
+				if got := dom.TextContent(dom.QuerySelector(doc, "p")); got != tt.want {
+					t.Errorf("%s() = %+q, want %+q", parserName, got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,599 @@
+package dom
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"weak"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// sourcePositionKey is the name of the attribute which is used to mark the
+// start tags before parsing. It's put before the other attributes, so the
+// parser keeps it even if the document happens to use the same name.
+const sourcePositionKey = "#source-position"
+
+// Position is a location in the source of a document.
+type Position struct {
+	// Offset is the byte offset in the original input, before it's
+	// decoded into UTF-8, starting from 0.
+	Offset int
+
+	// Line is the line number, starting from 1.
+	Line int
+
+	// Column is the column number in characters, starting from 1.
+	Column int
+}
+
+// IsValid reports whether the position is known.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// SourceRange is the part of the source between Start (inclusive)
+// and End (exclusive).
+type SourceRange struct {
+	Start Position
+	End   Position
+}
+
+// NodePosition is the location of the tags of an element in the source.
+type NodePosition struct {
+	// StartTag is the location of the start tag, e.g. `<p class="a">`.
+	StartTag SourceRange
+
+	// EndTag is the location of the end tag, e.g. `</p>`. It's zero value
+	// if the end tag is omitted, e.g. for void elements.
+	EndTag SourceRange
+}
+
+// HasEndTag reports whether the end tag of the element exists in source.
+func (p NodePosition) HasEndTag() bool {
+	return p.EndTag.Start.IsValid()
+}
+
+// sourcePositions contains the positions of elements in documents that
+// parsed with ParseOptions.TrackPositions, grouped by the document node.
+// The elements are referred weakly, so the positions don't keep their
+// document alive.
+var sourcePositions nodeTable[map[weak.Pointer[html.Node]]NodePosition]
+
+// SourcePosition returns the location of the tags of element in the source
+// of its document. It only works for documents that parsed with
+// ParseOptions.TrackPositions and only for elements which are created from
+// a start tag, so it returns false for the implied elements (e.g. <tbody>
+// that's not written in the source), elements created after parsing and
+// elements that removed from their document.
+//
+// The end tag is matched to the nearest unclosed start tag with the same
+// name, after closing the elements whose end tag is implied (e.g. <p> before
+// <div>), so it might still be different with how the parser closes the
+// element in malformed document.
+func SourcePosition(node *html.Node) (NodePosition, bool) {
+	root := rootNode(node)
+	if root == nil {
+		return NodePosition{}, false
+	}
+
+	nodePositions, _ := sourcePositions.get(root)
+	position, exist := nodePositions[weak.Make(node)]
+	return position, exist
+}
+
+// ReleaseSourcePositions forgets the positions recorded for doc. The
+// positions are forgotten once the document is garbage collected anyway,
+// so this is only needed to free the memory while doc is still in use.
+func ReleaseSourcePositions(doc *html.Node) {
+	sourcePositions.delete(rootNode(doc))
+}
+
+// registerSourcePositions removes the marker attributes from the elements
+// in doc and returns the elements by their marker, where only the first
+// element is kept if the parser cloned the element (e.g. for misnested
+// formatting elements). If keep is true, the positions that referred by
+// the markers are recorded for SourcePosition.
+func registerSourcePositions(doc *html.Node, positions []NodePosition, keep bool) []*html.Node {
+	nodePositions := make(map[weak.Pointer[html.Node]]NodePosition)
+	nodes := make([]*html.Node, len(positions))
+
+	for node := range Descendants(doc) {
+		if node.Type != html.ElementNode {
+			continue
+		}
+
+		for i, attr := range node.Attr {
+			if attr.Key != sourcePositionKey || attr.Namespace != "" {
+				continue
+			}
+
+			if idx, err := strconv.Atoi(attr.Val); err == nil && idx >= 0 && idx < len(positions) {
+				if keep {
+					nodePositions[weak.Make(node)] = positions[idx]
+				}
+				if nodes[idx] == nil {
+					nodes[idx] = node
+				}
+			}
+			node.Attr = append(node.Attr[:i], node.Attr[i+1:]...)
+			break
+		}
+	}
+
+	if keep {
+		sourcePositions.set(doc, nodePositions)
+	}
+	return nodes
+}
+
+// openTag is an element whose end tag is not found yet while marking
+// the source positions. The index is its marker.
+type openTag struct {
+	name  string
+	index int
+
+	// namespace is "svg" or "math" for foreign elements, and
+	// integrationPoint tells whether HTML can be put inside it.
+	namespace        string
+	integrationPoint bool
+
+	// depth is the least depth of the element in the parsed tree. The
+	// table structure, the reopened formatting elements and the elements
+	// which may be ignored don't add to it, since the parser may put the
+	// elements inside them elsewhere.
+	depth int
+
+	// inSelect tells whether the element is inside <select>, where the
+	// parser ignores most of the tags.
+	inSelect bool
+}
+
+// isInSelect reports whether the current element in openTags is <select>
+// or inside it.
+func isInSelect(openTags []openTag) bool {
+	if len(openTags) == 0 {
+		return false
+	}
+
+	current := openTags[len(openTags)-1]
+	return current.inSelect || (current.name == "select" && current.namespace == "")
+}
+
+// ignorableStartTags are the start tags which may be ignored by the parser.
+var ignorableStartTags = map[string]struct{}{
+	"html": {}, "head": {}, "body": {}, "frameset": {}, "frame": {}, "form": {},
+	"caption": {}, "col": {}, "colgroup": {}, "tbody": {}, "td": {}, "tfoot": {},
+	"th": {}, "thead": {}, "tr": {},
+}
+
+// openDepth returns the least depth of the current element in openTags,
+// where <body> has depth 2.
+func openDepth(openTags []openTag) int {
+	if len(openTags) == 0 {
+		return 2
+	}
+	return openTags[len(openTags)-1].depth
+}
+
+// reopenTag puts the formatting element which is closed by misnested tag
+// back into openTags, since the parser reopens it when it's needed.
+func reopenTag(openTags []openTag, tag openTag) []openTag {
+	tag.depth = openDepth(openTags)
+	return append(openTags, tag)
+}
+
+// isForeignContent reports whether the start tag with the specified
+// name is parsed as a foreign element when t is the current element.
+func (t openTag) isForeignContent(name string) bool {
+	switch {
+	case t.namespace == "", t.integrationPoint:
+		return false
+	case t.namespace == "math" && isMathMLTextIntegrationPoint(t.name):
+		return name == "mglyph" || name == "malignmark"
+	case t.namespace == "math" && t.name == "annotation-xml":
+		return name != "svg"
+	default:
+		return true
+	}
+}
+
+// isMathMLTextIntegrationPoint reports whether the MathML element with
+// the specified name contains HTML elements instead of MathML.
+func isMathMLTextIntegrationPoint(tagName string) bool {
+	switch tagName {
+	case "mi", "mo", "mn", "ms", "mtext":
+		return true
+	default:
+		return false
+	}
+}
+
+// isHTMLIntegrationPoint reports whether the foreign element whose start
+// tag is the current token of z contains HTML elements.
+func isHTMLIntegrationPoint(namespace, tagName string, z *html.Tokenizer, hasAttr bool) bool {
+	switch {
+	case namespace == "svg":
+		return tagName == "desc" || tagName == "foreignobject" || tagName == "title"
+	case namespace == "math" && tagName == "annotation-xml":
+		for hasAttr {
+			var key, val []byte
+			key, val, hasAttr = z.TagAttr()
+			if string(key) == "encoding" {
+				encoding := strings.ToLower(string(val))
+				return encoding == "text/html" || encoding == "application/xhtml+xml"
+			}
+		}
+	}
+	return false
+}
+
+// enterStartTag closes the foreign elements which can't contain the start
+// tag with the specified name, which is the current token of z, like the
+// parser does. It returns whether the tag is parsed as a foreign element.
+func enterStartTag(openTags []openTag, name string, z *html.Tokenizer, hasAttr bool) ([]openTag, bool) {
+	current := func() openTag {
+		if len(openTags) == 0 {
+			return openTag{}
+		}
+		return openTags[len(openTags)-1]
+	}
+
+	if !current().isForeignContent(name) {
+		return openTags, false
+	}
+
+	if !breaksOutOfForeignContent(name, z, hasAttr) {
+		return openTags, true
+	}
+
+	for len(openTags) > 0 && current().isForeignContent(name) {
+		openTags = openTags[:len(openTags)-1]
+	}
+	return openTags, false
+}
+
+// pushStartTag closes the elements whose end tag is implied by tag, then
+// puts tag into openTags unless it's closed right away. The start tag must
+// be the current token of z, which is told not to treat the content of
+// foreign element as raw text (e.g. <title> in <svg>).
+func pushStartTag(openTags []openTag, tag openTag, foreign, selfClosing bool, z *html.Tokenizer, hasAttr bool) []openTag {
+	switch {
+	case foreign:
+		tag.namespace = openTags[len(openTags)-1].namespace
+		z.NextIsNotRawText()
+	case tag.name == "svg" || tag.name == "math":
+		tag.namespace = tag.name
+	default:
+		openTags = closeImpliedTags(openTags, tag.name)
+	}
+
+	if tag.namespace != "" {
+		tag.integrationPoint = isHTMLIntegrationPoint(tag.namespace, tag.name, z, hasAttr)
+	}
+
+	_, ignorable := ignorableStartTags[tag.name]
+	tag.inSelect = isInSelect(openTags)
+	tag.depth = openDepth(openTags)
+	if !tag.inSelect && (tag.namespace != "" || ((!ignorable || tag.name == "form") && tag.name != "table")) {
+		tag.depth++
+	}
+
+	if selfClosing || (tag.namespace == "" && isVoidTagName(tag.name)) {
+		return openTags
+	}
+
+	// Nested form is ignored by the parser
+	if tag.name == "form" && tag.namespace == "" {
+		for _, open := range openTags {
+			if open.name == "form" && open.namespace == "" {
+				return openTags
+			}
+		}
+	}
+
+	return append(openTags, tag)
+}
+
+// breaksOutOfForeignContent reports whether the start tag which is the
+// current token of z closes the foreign elements, e.g. <p> inside <svg>.
+func breaksOutOfForeignContent(tagName string, z *html.Tokenizer, hasAttr bool) bool {
+	if tagName == "font" {
+		for hasAttr {
+			var key []byte
+			key, _, hasAttr = z.TagAttr()
+			switch string(key) {
+			case "color", "face", "size":
+				return true
+			}
+		}
+		return false
+	}
+
+	_, breakout := foreignBreakoutTags[tagName]
+	return breakout
+}
+
+// foreignBreakoutTags are the HTML elements which can't be put inside
+// foreign elements.
+var foreignBreakoutTags = map[string]struct{}{
+	"b": {}, "big": {}, "blockquote": {}, "body": {}, "br": {}, "center": {},
+	"code": {}, "dd": {}, "div": {}, "dl": {}, "dt": {}, "em": {}, "embed": {},
+	"h1": {}, "h2": {}, "h3": {}, "h4": {}, "h5": {}, "h6": {}, "head": {},
+	"hr": {}, "i": {}, "img": {}, "li": {}, "listing": {}, "menu": {},
+	"meta": {}, "nobr": {}, "ol": {}, "p": {}, "pre": {}, "ruby": {}, "s": {},
+	"small": {}, "span": {}, "strong": {}, "strike": {}, "sub": {}, "sup": {},
+	"table": {}, "tt": {}, "u": {}, "ul": {}, "var": {},
+}
+
+// markSourcePositions decodes content into UTF-8 and adds marker attribute
+// to each start tag, whose value is the index of its position in the returned
+// slice. base is the offset of content in the original input, e.g. when the
+// byte order mark is removed from it. If diags is not nil, the problems found
+// in the tags are collected into it.
+func markSourcePositions(enc encoding.Encoding, content []byte, base int, diags *diagnosticCollector) ([]byte, []NodePosition, error) {
+	decoded, offsets, err := decodeWithOffsets(enc, content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		marked    bytes.Buffer
+		positions []NodePosition
+		openTags  []openTag
+		offset    int
+	)
+
+	locator := sourceLocator{source: decoded, offsets: offsets, base: base}
+	// currentTag returns the element where the next token is put into
+	currentTag := func() openTag {
+		if len(openTags) == 0 {
+			return openTag{}
+		}
+		return openTags[len(openTags)-1]
+	}
+
+	// Like the parser, CDATA sections are only allowed in foreign content
+	// and the elements inside it never contain raw text (e.g. <title> in
+	// <svg>), otherwise the markers could be put into the text.
+	z := html.NewTokenizer(bytes.NewReader(decoded))
+	for {
+		z.AllowCDATA(currentTag().namespace != "")
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		raw := z.Raw()
+		start, end := offset, offset+len(raw)
+		offset = end
+
+		// The element where the token is put into
+		parent := currentTag().name
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tagName, hasAttr := z.TagName()
+			name := string(tagName)
+			index := len(positions)
+			rng := SourceRange{locator.locate(start), locator.locate(end)}
+			positions = append(positions, NodePosition{StartTag: rng})
+
+			var foreign bool
+			openTags, foreign = enterStartTag(openTags, name, z, hasAttr)
+			if !foreign {
+				parent = currentTag().name
+				if diags != nil && isTableContext(parent) && !isAllowedInTable(name, z, hasAttr) {
+					diags.add(DiagnosticFosterParented, rng, index,
+						"<%s> is not allowed in <%s>, it's moved before the table", name, parent)
+				}
+			}
+
+			tag := openTag{name: name, index: index}
+			openTags = pushStartTag(openTags, tag, foreign, tokenType == html.SelfClosingTagToken, z, hasAttr)
+
+			// Put the marker right after the tag name
+			nameEnd := 1 + bytes.IndexAny(raw[1:], " \t\n\f\r/>")
+			if nameEnd == 0 {
+				nameEnd = len(raw)
+			}
+
+			marked.Write(raw[:nameEnd])
+			marked.WriteString(" " + sourcePositionKey + `="` + strconv.Itoa(index) + `"`)
+			marked.Write(raw[nameEnd:])
+			continue
+
+		case html.EndTagToken:
+			tagName, _ := z.TagName()
+			name := string(tagName)
+			rng := SourceRange{locator.locate(start), locator.locate(end)}
+
+			// End tag in foreign content closes the matching foreign
+			// element, without caring about the elements inside it.
+			foreignMatched := false
+			for i := len(openTags) - 1; i >= 0 && openTags[i].namespace != ""; i-- {
+				if openTags[i].name == name {
+					positions[openTags[i].index].EndTag = rng
+					openTags = openTags[:i]
+					foreignMatched = true
+					break
+				}
+			}
+
+			matched := -1
+			for i := len(openTags) - 1; i >= 0 && !foreignMatched; i-- {
+				if openTags[i].name == name {
+					matched = i
+					break
+				}
+			}
+
+			switch {
+			case foreignMatched:
+			case matched >= 0:
+				matchedTag := openTags[matched]
+				positions[matchedTag.index].EndTag = rng
+				unclosedTags := append([]openTag(nil), openTags[matched+1:]...)
+				openTags = openTags[:matched]
+
+				// End tag of formatting element doesn't close the elements
+				// inside it, while the others only close the elements whose
+				// end tag can be omitted. Either way, the parser reopens the
+				// formatting elements, so their end tags still can be matched.
+				_, isFormatting := formattingElements[name]
+				reported := false
+				for _, unclosed := range unclosedTags {
+					_, implied := impliedEndTags[unclosed.name]
+					if (isFormatting || !implied) && !reported {
+						diags.add(DiagnosticMisnestedTag, rng, matchedTag.index,
+							"</%s> closes <%s> which is still open", name, unclosed.name)
+						reported = true
+					}
+
+					if _, formatting := formattingElements[unclosed.name]; isFormatting || formatting {
+						openTags = reopenTag(openTags, unclosed)
+					}
+				}
+
+			case name != "html" && name != "head" && name != "body":
+				diags.add(DiagnosticStrayEndTag, rng, -1, "</%s> doesn't have matching start tag", name)
+			}
+
+		case html.TextToken:
+			if diags != nil && isTableContext(parent) && strings.Trim(string(raw), " \t\n\f\r") != "" {
+				rng := SourceRange{locator.locate(start), locator.locate(end)}
+				diags.add(DiagnosticFosterParented, rng, -1, "text is not allowed in <%s>, it's moved before the table", parent)
+			}
+		}
+
+		marked.Write(raw)
+	}
+
+	// Keep the trailing bytes which are not emitted as token, e.g. unclosed tag
+	marked.Write(decoded[offset:])
+	return marked.Bytes(), positions, nil
+}
+
+// offsetMark maps an offset in the decoded content to an offset in the
+// source. The following offsets until the next mark are mapped linearly.
+type offsetMark struct {
+	decoded int
+	source  int
+}
+
+// decodeWithOffsets decodes content like transform.Bytes, while recording
+// the marks for mapping the offsets of decoded content back to content.
+func decodeWithOffsets(enc encoding.Encoding, content []byte) ([]byte, []offsetMark, error) {
+	// If decoding doesn't change anything (e.g. valid UTF-8 or ASCII),
+	// the offsets are the same.
+	decoded, _, err := transform.Bytes(enc.NewDecoder(), content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(decoded, content) {
+		return decoded, []offsetMark{{0, 0}}, nil
+	}
+
+	var (
+		marks []offsetMark
+		buf   [16]byte
+		src   int
+	)
+
+	decoded = decoded[:0]
+
+	addMark := func() {
+		if n := len(marks); n == 0 || marks[n-1].source-marks[n-1].decoded != src-len(decoded) {
+			marks = append(marks, offsetMark{decoded: len(decoded), source: src})
+		}
+	}
+
+	// Decode one character at a time by giving the decoder the smallest
+	// buffer that fits the next character.
+	decoder := enc.NewDecoder()
+	for src < len(content) {
+		addMark()
+
+		var (
+			nDst, nSrc int
+			err        error
+		)
+
+		for size := 1; size <= len(buf); size++ {
+			nDst, nSrc, err = decoder.Transform(buf[:size], content[src:], true)
+			if err != transform.ErrShortDst || nDst > 0 {
+				break
+			}
+		}
+
+		if err != nil && err != transform.ErrShortDst {
+			return nil, nil, err
+		}
+
+		if nDst == 0 && nSrc == 0 {
+			break
+		}
+
+		decoded = append(decoded, buf[:nDst]...)
+		src += nSrc
+	}
+
+	addMark()
+	return decoded, marks, nil
+}
+
+// sourceLocator converts offsets in the decoded content into Positions.
+// The offsets must be located in increasing order.
+type sourceLocator struct {
+	source  []byte
+	offsets []offsetMark
+	base    int
+
+	// Position of the last located offset
+	offset int
+	line   int
+	column int
+}
+
+func (l *sourceLocator) locate(offset int) Position {
+	if l.line == 0 {
+		l.line, l.column = 1, 1
+	}
+
+	for l.offset < offset {
+		r, size := utf8.DecodeRune(l.source[l.offset:])
+		switch {
+		case r == '\n', r == '\r' && (l.offset+1 >= len(l.source) || l.source[l.offset+1] != '\n'):
+			l.line++
+			l.column = 1
+		case r == '\r':
+		default:
+			l.column++
+		}
+		l.offset += size
+	}
+
+	idx := sort.Search(len(l.offsets), func(i int) bool {
+		return l.offsets[i].decoded > offset
+	}) - 1
+
+	sourceOffset := offset
+	if idx >= 0 {
+		sourceOffset = l.offsets[idx].source + offset - l.offsets[idx].decoded
+	}
+
+	return Position{
+		Offset: l.base + sourceOffset,
+		Line:   l.line,
+		Column: l.column,
+	}
+}
+
+func isVoidTagName(name string) bool {
+	return IsVoidElement(&html.Node{Type: html.ElementNode, Data: name})
+}
@@ -0,0 +1,218 @@
+package dom_test
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestSourcePosition(t *testing.T) {
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	htmlSource := "<!DOCTYPE html>\n" +
+		"<html><head><meta charset=\"%s\"></head>\n" +
+		"<body>\n" +
+		"  <div id=\"main\">吾輩は<b id=\"bold\">猫</b>である。<br id=\"br\">\n" +
+		"  <p id=\"para\" class=\"名前\">まだ無い\n" +
+		"  </div>\n" +
+		"  <table id=\"table\"><tr id=\"row\"><td>Cell</td></tr></table>\n" +
+		"</body></html>"
+
+	type tagPosition struct {
+		startTag  string
+		endTag    string
+		line      int
+		column    int
+		endLine   int
+		endColumn int
+	}
+
+	expected := map[string]tagPosition{
+		"main":  {`<div id="main">`, "</div>", 4, 3, 6, 3},
+		"bold":  {`<b id="bold">`, "</b>", 4, 21, 4, 35},
+		"br":    {`<br id="br">`, "", 4, 43, 0, 0},
+		"para":  {`<p id="para" class="名前">`, "", 5, 3, 0, 0},
+		"table": {`<table id="table">`, "</table>", 7, 3, 7, 52},
+		"row":   {`<tr id="row">`, "</tr>", 7, 21, 7, 47},
+	}
+
+	encodings := map[string]encoding.Encoding{
+		"utf-8":     unicode.UTF8,
+		"shift_jis": japanese.ShiftJIS,
+		"utf-16le":  utf16LE,
+	}
+
+	for charset, enc := range encodings {
+		t.Run(charset, func(t *testing.T) {
+			source := strings.Replace(htmlSource, "%s", charset, 1)
+			content := encodeString(t, enc, source)
+
+			doc, _, err := dom.ParseWithOptions(bytes.NewReader(content), dom.ParseOptions{TrackPositions: true})
+			if err != nil {
+				t.Fatalf("ParseWithOptions() error = %v", err)
+			}
+			defer dom.ReleaseSourcePositions(doc)
+
+			// decode returns the original text in the specified range
+			decode := func(r dom.SourceRange) string {
+				text, err := enc.NewDecoder().Bytes(content[r.Start.Offset:r.End.Offset])
+				if err != nil {
+					t.Fatalf("failed to decode: %v", err)
+				}
+				return string(text)
+			}
+
+			for id, want := range expected {
+				node := dom.GetElementByID(doc, id)
+				position, ok := dom.SourcePosition(node)
+				if !ok {
+					t.Errorf("SourcePosition(%s) not found", id)
+					continue
+				}
+
+				if got := decode(position.StartTag); got != want.startTag {
+					t.Errorf("SourcePosition(%s) start tag = %q, want %q", id, got, want.startTag)
+				}
+
+				start := position.StartTag.Start
+				if start.Line != want.line || start.Column != want.column {
+					t.Errorf("SourcePosition(%s) start = %d:%d, want %d:%d",
+						id, start.Line, start.Column, want.line, want.column)
+				}
+
+				if position.HasEndTag() != (want.endTag != "") {
+					t.Errorf("SourcePosition(%s).HasEndTag() = %v, want %v", id, position.HasEndTag(), want.endTag != "")
+				}
+
+				if !position.HasEndTag() {
+					continue
+				}
+
+				if got := decode(position.EndTag); got != want.endTag {
+					t.Errorf("SourcePosition(%s) end tag = %q, want %q", id, got, want.endTag)
+				}
+
+				end := position.EndTag.Start
+				if end.Line != want.endLine || end.Column != want.endColumn {
+					t.Errorf("SourcePosition(%s) end = %d:%d, want %d:%d",
+						id, end.Line, end.Column, want.endLine, want.endColumn)
+				}
+			}
+
+			// Implied element doesn't have position
+			if _, ok := dom.SourcePosition(dom.QuerySelector(doc, "tbody")); ok {
+				t.Errorf("SourcePosition(tbody) found, want not found")
+			}
+
+			// Markers are not visible in document
+			if html := dom.OuterHTML(doc); strings.Contains(html, "source-position") {
+				t.Errorf("OuterHTML() contains marker: %s", html)
+			}
+		})
+	}
+}
+
+func TestSourcePositionForeignContent(t *testing.T) {
+	htmlSource := `<div><svg id="svg"><![CDATA[ x > <b> ]]><title id="title"><b id="bold">Title</b></title>` +
+		`<style>a { }</style></svg><math><mi><p id="para">x</p></mi></math><p>After</p></div>`
+
+	want, err := dom.FastParse(strings.NewReader(htmlSource))
+	if err != nil {
+		t.Fatalf("FastParse() error = %v", err)
+	}
+
+	doc, _, err := dom.ParseWithOptions(strings.NewReader(htmlSource), dom.ParseOptions{TrackPositions: true})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	defer dom.ReleaseSourcePositions(doc)
+
+	// Markers are not put into the character data of foreign content
+	if got := dom.OuterHTML(doc); got != dom.OuterHTML(want) {
+		t.Errorf("OuterHTML() = %q, want %q", got, dom.OuterHTML(want))
+	}
+
+	// Elements inside the raw text elements of foreign content are found
+	for _, id := range []string{"svg", "title", "bold", "para"} {
+		node := dom.GetElementByID(doc, id)
+		position, ok := dom.SourcePosition(node)
+		if !ok || !position.HasEndTag() {
+			t.Errorf("SourcePosition(%s) = %+v, %v, want with end tag", id, position, ok)
+		}
+	}
+}
+
+func TestSourcePositionLifetime(t *testing.T) {
+	source := `<div id="main"><p id="para">Hello</p></div>`
+
+	// Positions are not tracked by default
+	doc, _, err := dom.ParseWithOptions(strings.NewReader(source), dom.ParseOptions{})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	if _, ok := dom.SourcePosition(dom.GetElementByID(doc, "main")); ok {
+		t.Errorf("SourcePosition() found without TrackPositions")
+	}
+
+	doc, _, err = dom.ParseStream(strings.NewReader(source), dom.ParseOptions{TrackPositions: true})
+	if err != nil {
+		t.Fatalf("ParseStream() error = %v", err)
+	}
+
+	// Element which is created after parsing doesn't have position
+	newNode := dom.CreateElement("span")
+	dom.AppendChild(dom.GetElementByID(doc, "main"), newNode)
+	if _, ok := dom.SourcePosition(newNode); ok {
+		t.Errorf("SourcePosition() found for new element")
+	}
+
+	// Detached element is no longer part of the document
+	para := dom.GetElementByID(doc, "para")
+	if _, ok := dom.SourcePosition(para); !ok {
+		t.Errorf("SourcePosition() not found for para")
+	}
+
+	dom.RemoveNodes([]*html.Node{para}, nil)
+	if _, ok := dom.SourcePosition(para); ok {
+		t.Errorf("SourcePosition() found for detached element")
+	}
+
+	// Released document doesn't have positions
+	main := dom.GetElementByID(doc, "main")
+	dom.ReleaseSourcePositions(doc)
+	if _, ok := dom.SourcePosition(main); ok {
+		t.Errorf("SourcePosition() found after released")
+	}
+
+	if _, ok := dom.SourcePosition(nil); ok {
+		t.Errorf("SourcePosition(nil) found")
+	}
+}
+
+func TestSourcePositionWithoutRelease(t *testing.T) {
+	collected := make(chan struct{})
+	func() {
+		source := `<div id="main"><p id="para">Hello</p></div>`
+		doc, _, err := dom.ParseWithOptions(strings.NewReader(source), dom.ParseOptions{TrackPositions: true})
+		if err != nil {
+			t.Fatalf("ParseWithOptions() error = %v", err)
+		}
+
+		if _, ok := dom.SourcePosition(dom.GetElementByID(doc, "para")); !ok {
+			t.Errorf("SourcePosition() not found for para")
+		}
+		runtime.AddCleanup(doc, func(ch chan struct{}) { close(ch) }, collected)
+	}()
+
+	// Document which is never released doesn't stay alive
+	if !waitCollected(collected) {
+		t.Errorf("document with positions is not garbage collected")
+	}
+}
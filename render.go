@@ -0,0 +1,439 @@
+package dom
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// XML namespaces of the elements that may exist in HTML document.
+const (
+	namespaceXHTML  = "http://www.w3.org/1999/xhtml"
+	namespaceSVG    = "http://www.w3.org/2000/svg"
+	namespaceMathML = "http://www.w3.org/1998/Math/MathML"
+	namespaceXLink  = "http://www.w3.org/1999/xlink"
+)
+
+// RenderMode is the syntax used to serialize the nodes.
+type RenderMode int
+
+const (
+	// RenderModeHTML serializes the nodes as HTML, just like html.Render.
+	RenderModeHTML RenderMode = iota
+
+	// RenderModeXHTML serializes the nodes as well-formed XHTML, which can
+	// be read by any XML parser, e.g. for EPUB. Void elements are self-closed,
+	// content of <script> and <style> is put inside CDATA section, and the
+	// namespaces of HTML, SVG and MathML elements are declared. Elements and
+	// attributes whose name is not valid in XML are dropped, although the
+	// content of such element is kept. Raw nodes are escaped as text.
+	RenderModeXHTML
+)
+
+// RenderOptions is the options for Render.
+type RenderOptions struct {
+	Mode RenderMode
+}
+
+// Render writes the serialization of node and its descendants to w,
+// using the syntax specified in opts.
+func Render(w io.Writer, node *html.Node, opts RenderOptions) error {
+	if node == nil {
+		return nil
+	}
+
+	if opts.Mode != RenderModeXHTML {
+		return html.Render(w, node)
+	}
+
+	bw := bufio.NewWriter(w)
+	xw := xhtmlWriter{w: bw}
+	if err := xw.render(node); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// OuterXHTML returns an XHTML serialization of the element and its
+// descendants. If the node can't be rendered, it returns empty string.
+// Use Render() to get the render error.
+func OuterXHTML(node *html.Node) string {
+	var buffer bytes.Buffer
+	if err := Render(&buffer, node, RenderOptions{Mode: RenderModeXHTML}); err != nil {
+		return ""
+	}
+	return buffer.String()
+}
+
+// InnerXHTML returns the XHTML serialization of the content of an element.
+// If the content can't be rendered, it returns empty string. Use Render()
+// to get the render error.
+func InnerXHTML(node *html.Node) string {
+	if node == nil {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if err := Render(&buffer, child, RenderOptions{Mode: RenderModeXHTML}); err != nil {
+			return ""
+		}
+	}
+
+	return strings.TrimSpace(buffer.String())
+}
+
+// xhtmlWriter serializes nodes as XHTML.
+type xhtmlWriter struct {
+	w      *bufio.Writer
+	scopes []xmlScope
+}
+
+// xmlScope is the state of an element that's being written.
+type xmlScope struct {
+	// endTag is the end tag to write once the content of the element is
+	// written. It's empty if the element is self-closed, or dropped
+	// because of its name in which case only its content is written.
+	endTag string
+
+	// written is true if the start tag of the element is written.
+	written bool
+
+	// namespace is the default namespace inside the element.
+	namespace string
+
+	// prefixes are the namespace prefixes declared by the element.
+	prefixes []string
+}
+
+func (x *xhtmlWriter) render(root *html.Node) error {
+	var err error
+	w := newTreeWalk(root, true)
+	w.onLeave = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			x.endElement()
+		}
+	}
+
+	skipChildren := false
+	for w.next(skipChildren) {
+		n := w.current
+		skipChildren = false
+
+		switch n.Type {
+		case html.DocumentNode:
+		case html.DoctypeNode:
+			x.doctype(n)
+		case html.CommentNode:
+			x.comment(n.Data)
+		case html.TextNode:
+			x.text(n)
+		case html.RawNode:
+			// Raw markup might not be well-formed, so it's kept as text
+			x.w.WriteString(escapeXMLText(toValidXMLText(n.Data), false))
+		case html.ElementNode:
+			skipChildren = x.startElement(n)
+		default:
+			err = errors.New("dom: cannot render an ErrorNode node")
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *xhtmlWriter) doctype(n *html.Node) {
+	name := n.Data
+	if !isXMLName(name) {
+		name = "html"
+	}
+
+	x.w.WriteString("<!DOCTYPE " + name)
+
+	var public, system string
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "public":
+			public = attr.Val
+		case "system":
+			system = attr.Val
+		}
+	}
+
+	// Identifiers can't contain the quote used to wrap them
+	public = strings.ReplaceAll(public, `"`, "")
+	system = strings.ReplaceAll(system, `"`, "")
+
+	switch {
+	case public != "":
+		x.w.WriteString(` PUBLIC "` + public + `" "` + system + `"`)
+	case system != "":
+		x.w.WriteString(` SYSTEM "` + system + `"`)
+	}
+
+	x.w.WriteString(">")
+}
+
+func (x *xhtmlWriter) comment(data string) {
+	// Comment can't contain "--" or end with "-"
+	data = toValidXMLText(data)
+	for strings.Contains(data, "--") {
+		data = strings.ReplaceAll(data, "--", "- -")
+	}
+
+	if strings.HasSuffix(data, "-") {
+		data += " "
+	}
+
+	x.w.WriteString("<!--" + data + "-->")
+}
+
+func (x *xhtmlWriter) text(n *html.Node) {
+	data := toValidXMLText(n.Data)
+
+	// Content of script and style is not escaped in HTML,
+	// so it's put inside CDATA section instead.
+	if p := n.Parent; p != nil && p.Type == html.ElementNode && p.Namespace == "" &&
+		(p.Data == "script" || p.Data == "style") && strings.ContainsAny(data, "<&") {
+		x.w.WriteString("<![CDATA[" + strings.ReplaceAll(data, "]]>", "]]]]><![CDATA[>") + "]]>")
+		return
+	}
+
+	x.w.WriteString(escapeXMLText(data, false))
+}
+
+// startElement writes the start tag of element n. It returns true
+// if the element is self-closed, so its children must be skipped.
+func (x *xhtmlWriter) startElement(n *html.Node) bool {
+	namespace := namespaceXHTML
+	switch n.Namespace {
+	case "svg":
+		namespace = namespaceSVG
+	case "math":
+		namespace = namespaceMathML
+	}
+
+	tagName := n.Data
+	if n.Namespace == "" {
+		tagName = strings.ToLower(tagName)
+	}
+
+	x.scopes = append(x.scopes, xmlScope{namespace: namespace})
+	scope := &x.scopes[len(x.scopes)-1]
+
+	// Collect the namespace prefixes declared by this element
+	declarations := make(map[string]string)
+	for _, attr := range n.Attr {
+		prefix, ok := xmlnsPrefix(attr)
+		if !ok || prefix == "" || prefix == "xml" || prefix == "xmlns" || attr.Val == "" ||
+			!isXMLName(prefix) || strings.Contains(prefix, ":") {
+			continue
+		}
+
+		if _, exist := declarations[prefix]; !exist {
+			declarations[prefix] = attr.Val
+			scope.prefixes = append(scope.prefixes, prefix)
+		}
+	}
+
+	if !x.isValidXMLName(tagName) {
+		scope.prefixes = nil
+		return false
+	}
+
+	scope.written = true
+	x.w.WriteString("<" + tagName)
+	if namespace != x.parentNamespace() {
+		x.w.WriteString(` xmlns="` + namespace + `"`)
+	}
+
+	written := make(map[string]struct{})
+	writeAttr := func(name, value string) {
+		if _, exist := written[name]; exist {
+			return
+		}
+		written[name] = struct{}{}
+		x.w.WriteString(" " + name + `="` + escapeXMLText(toValidXMLText(value), true) + `"`)
+	}
+
+	for _, prefix := range scope.prefixes {
+		writeAttr("xmlns:"+prefix, declarations[prefix])
+	}
+
+	for _, attr := range n.Attr {
+		if _, isDeclaration := xmlnsPrefix(attr); isDeclaration {
+			continue
+		}
+
+		name := attr.Key
+		if attr.Namespace != "" {
+			name = attr.Namespace + ":" + attr.Key
+		}
+
+		// Declare xlink when it's used by the attributes of SVG
+		if attr.Namespace == "xlink" && !x.isDeclaredPrefix("xlink") {
+			scope.prefixes = append(scope.prefixes, "xlink")
+			writeAttr("xmlns:xlink", namespaceXLink)
+		}
+
+		if x.isValidXMLName(name) {
+			writeAttr(name, attr.Val)
+		}
+	}
+
+	// Void element can't have content, while empty HTML element must
+	// not be self-closed to keep it compatible with HTML parser.
+	if IsVoidElement(n) || (n.FirstChild == nil && n.Namespace != "") {
+		x.w.WriteString("/>")
+		return true
+	}
+
+	x.w.WriteString(">")
+	scope.endTag = "</" + tagName + ">"
+	return false
+}
+
+// endElement writes the end tag of the current element.
+func (x *xhtmlWriter) endElement() {
+	scope := x.scopes[len(x.scopes)-1]
+	x.scopes = x.scopes[:len(x.scopes)-1]
+	x.w.WriteString(scope.endTag)
+}
+
+// parentNamespace returns the default namespace of the nearest
+// written ancestor of the current element.
+func (x *xhtmlWriter) parentNamespace() string {
+	for i := len(x.scopes) - 2; i >= 0; i-- {
+		if x.scopes[i].written {
+			return x.scopes[i].namespace
+		}
+	}
+	return ""
+}
+
+// isDeclaredPrefix reports whether the namespace prefix is declared
+// by the current element or its ancestors.
+func (x *xhtmlWriter) isDeclaredPrefix(prefix string) bool {
+	if prefix == "xml" {
+		return true
+	}
+
+	for i := len(x.scopes) - 1; i >= 0; i-- {
+		for _, declared := range x.scopes[i].prefixes {
+			if declared == prefix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isValidXMLName reports whether name is a valid XML name, whose namespace
+// prefix (if any) is declared.
+func (x *xhtmlWriter) isValidXMLName(name string) bool {
+	if !isXMLName(name) {
+		return false
+	}
+
+	prefix, local, hasPrefix := strings.Cut(name, ":")
+	if !hasPrefix {
+		return true
+	}
+
+	return prefix != "" && local != "" && !strings.Contains(local, ":") &&
+		prefix != "xmlns" && x.isDeclaredPrefix(prefix)
+}
+
+// xmlnsPrefix returns the prefix declared by attr if it's a namespace
+// declaration, e.g. "xlink" for xmlns:xlink, or empty string for xmlns.
+func xmlnsPrefix(attr html.Attribute) (string, bool) {
+	switch {
+	case attr.Namespace == "xmlns":
+		return attr.Key, true
+	case attr.Namespace != "":
+		return "", false
+	case attr.Key == "xmlns":
+		return "", true
+	case strings.HasPrefix(attr.Key, "xmlns:"):
+		return strings.TrimPrefix(attr.Key, "xmlns:"), true
+	default:
+		return "", false
+	}
+}
+
+// isXMLName reports whether name is a valid XML name.
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || unicode.IsLetter(r):
+		case i > 0 && (r == '-' || r == '.' || r == '·' || unicode.IsDigit(r) ||
+			unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// toValidXMLText replaces the characters which are not allowed in XML,
+// including invalid UTF-8, with U+FFFD replacement character.
+func toValidXMLText(s string) string {
+	isValid := func(r rune) bool {
+		return r == '\t' || r == '\n' || r == '\r' ||
+			(r >= 0x20 && r <= 0xD7FF) || (r >= 0xE000 && r <= 0xFFFD) || r >= 0x10000
+	}
+
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if strings.IndexFunc(s, func(r rune) bool { return !isValid(r) }) < 0 {
+		return s
+	}
+
+	return strings.Map(func(r rune) rune {
+		if !isValid(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, s)
+}
+
+// escapeXMLText escapes the special characters in text. In attribute,
+// the quote and white spaces are escaped as well, so they're kept as they
+// are after attribute value normalization. ">" is only escaped when it's
+// part of "]]>", which is not allowed in XML text.
+func escapeXMLText(s string, inAttribute bool) string {
+	var sb strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '&':
+			sb.WriteString("&amp;")
+		case r == '<':
+			sb.WriteString("&lt;")
+		case r == '>' && strings.HasSuffix(s[:i], "]]"):
+			sb.WriteString("&gt;")
+		case r == '\r':
+			sb.WriteString("&#13;")
+		case inAttribute && r == '"':
+			sb.WriteString("&quot;")
+		case inAttribute && r == '\t':
+			sb.WriteString("&#9;")
+		case inAttribute && r == '\n':
+			sb.WriteString("&#10;")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
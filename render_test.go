@@ -0,0 +1,219 @@
+package dom_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+// decodeXML reads all tokens of s using strict XML decoder, and returns
+// the namespace of the elements by their local name.
+func decodeXML(t *testing.T, s string) map[string]string {
+	t.Helper()
+
+	namespaces := make(map[string]string)
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	decoder.Strict = true
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("xml.Decoder.Token() error = %v, in:\n%s", err, s)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			if _, exist := namespaces[start.Name.Local]; !exist {
+				namespaces[start.Name.Local] = start.Name.Space
+			}
+		}
+	}
+
+	return namespaces
+}
+
+func TestOuterXHTML(t *testing.T) {
+	const (
+		xhtmlNS  = "http://www.w3.org/1999/xhtml"
+		svgNS    = "http://www.w3.org/2000/svg"
+		mathMLNS = "http://www.w3.org/1998/Math/MathML"
+	)
+
+	tests := []struct {
+		name       string
+		htmlSource string
+		want       string
+		namespaces map[string]string
+	}{{
+		name:       "void elements and escaped attribute",
+		htmlSource: `<div class="a&b" title='say "hi"'>Line<br>Next<img src="a.png?x=1&y=2"><p></p></div>`,
+		want: `<div xmlns="http://www.w3.org/1999/xhtml" class="a&amp;b" title="say &quot;hi&quot;">` +
+			`Line<br/>Next<img src="a.png?x=1&amp;y=2"/><p></p></div>`,
+	}, {
+		name:       "script and style",
+		htmlSource: `<div><script>if (a < b && c) { x = "]]>"; }</script><style>p > a { color: red }</style></div>`,
+		want: `<div xmlns="http://www.w3.org/1999/xhtml">` +
+			`<script><![CDATA[if (a < b && c) { x = "]]]]><![CDATA[>"; }]]></script>` +
+			`<style>p > a { color: red }</style></div>`,
+	}, {
+		name:       "comment with double hyphen",
+		htmlSource: `<div><!-- a -- b ---></div>`,
+		want:       `<div xmlns="http://www.w3.org/1999/xhtml"><!-- a - - b - --></div>`,
+	}, {
+		name:       "invalid characters",
+		htmlSource: "<div title=\"a\x01b\">c\x02d ]]> e</div>",
+		want:       "<div xmlns=\"http://www.w3.org/1999/xhtml\" title=\"a�b\">c�d ]]&gt; e</div>",
+	}, {
+		name:       "invalid names",
+		htmlSource: `<div @click="go()" [x]="1" data-ok="yes"><fb:like href="x">Like</fb:like></div>`,
+		want:       `<div xmlns="http://www.w3.org/1999/xhtml" data-ok="yes">Like</div>`,
+	}, {
+		name:       "declared prefix",
+		htmlSource: `<div xmlns:og="http://ogp.me/ns#"><meta og:title="Title"><span foo:bar="x"></span></div>`,
+		want: `<div xmlns="http://www.w3.org/1999/xhtml" xmlns:og="http://ogp.me/ns#">` +
+			`<meta og:title="Title"/><span></span></div>`,
+	}, {
+		name: "svg",
+		htmlSource: `<div><svg viewBox="0 0 10 10"><use xlink:href="#a"></use>` +
+			`<foreignObject><p>Text</p></foreignObject></svg></div>`,
+		want: `<div xmlns="http://www.w3.org/1999/xhtml"><svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">` +
+			`<use xmlns:xlink="http://www.w3.org/1999/xlink" xlink:href="#a"/>` +
+			`<foreignObject><p xmlns="http://www.w3.org/1999/xhtml">Text</p></foreignObject></svg></div>`,
+		namespaces: map[string]string{
+			"div":           xhtmlNS,
+			"svg":           svgNS,
+			"use":           svgNS,
+			"foreignObject": svgNS,
+			"p":             xhtmlNS,
+		},
+	}, {
+		name:       "mathml",
+		htmlSource: `<div><math><mi>x</mi><mo>=</mo><mn>1</mn></math></div>`,
+		want: `<div xmlns="http://www.w3.org/1999/xhtml"><math xmlns="http://www.w3.org/1998/Math/MathML">` +
+			`<mi>x</mi><mo>=</mo><mn>1</mn></math></div>`,
+		namespaces: map[string]string{
+			"div":  xhtmlNS,
+			"math": mathMLNS,
+			"mi":   mathMLNS,
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := parseHTMLSource(tt.htmlSource)
+			if err != nil {
+				t.Fatalf("failed to parse source: %v", err)
+			}
+
+			node := dom.FirstElementChild(body)
+			got := dom.OuterXHTML(node)
+			if got != tt.want {
+				t.Errorf("OuterXHTML() = %q, want %q", got, tt.want)
+			}
+
+			namespaces := decodeXML(t, got)
+			for name, want := range tt.namespaces {
+				if namespaces[name] != want {
+					t.Errorf("namespace of <%s> = %q, want %q", name, namespaces[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestInnerXHTML(t *testing.T) {
+	node, err := parseHTMLSource(`<p>Hello <b>world</b><br></p>  `)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	want := `<p xmlns="http://www.w3.org/1999/xhtml">Hello <b>world</b><br/></p>`
+	if got := dom.InnerXHTML(node); got != want {
+		t.Errorf("InnerXHTML() = %q, want %q", got, want)
+	}
+
+	if got := dom.InnerXHTML(nil); got != "" {
+		t.Errorf("InnerXHTML(nil) = %q, want empty", got)
+	}
+}
+
+func TestRenderXHTMLDocument(t *testing.T) {
+	htmlSource := `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">` +
+		`<html lang="en"><head><title>A & B</title><meta charset="utf-8"></head>` +
+		`<body><p>One<p>Two<table><tr><td>Cell</table><svg><circle r="1"/></svg></body></html>`
+
+	doc, err := html.Parse(strings.NewReader(htmlSource))
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	var buffer bytes.Buffer
+	if err := dom.Render(&buffer, doc, dom.RenderOptions{Mode: dom.RenderModeXHTML}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	got := buffer.String()
+	wantPrefix := `<!DOCTYPE html PUBLIC "-//W3C//DTD HTML 4.01//EN" "http://www.w3.org/TR/html4/strict.dtd">` +
+		`<html xmlns="http://www.w3.org/1999/xhtml" lang="en"><head><title>A &amp; B</title><meta charset="utf-8"/></head>`
+	if !strings.HasPrefix(got, wantPrefix) {
+		t.Errorf("Render() = %q, want prefix %q", got, wantPrefix)
+	}
+
+	namespaces := decodeXML(t, got)
+	if namespaces["html"] != "http://www.w3.org/1999/xhtml" || namespaces["circle"] != "http://www.w3.org/2000/svg" {
+		t.Errorf("Render() namespaces = %v", namespaces)
+	}
+}
+
+func TestRender(t *testing.T) {
+	body, err := parseHTMLSource(`<div class="a&b">Line<br>Next</div>`)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	node := dom.FirstElementChild(body)
+
+	// HTML mode is the same as OuterHTML
+	var buffer bytes.Buffer
+	if err := dom.Render(&buffer, node, dom.RenderOptions{}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	if got, want := buffer.String(), dom.OuterHTML(node); got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	// Nil node renders nothing
+	buffer.Reset()
+	if err := dom.Render(&buffer, nil, dom.RenderOptions{Mode: dom.RenderModeXHTML}); err != nil || buffer.Len() != 0 {
+		t.Errorf("Render(nil) = %q, %v, want empty", buffer.String(), err)
+	}
+
+	// Raw node is escaped in XHTML mode, so the output stays well-formed
+	raw := dom.CreateElement("div")
+	dom.AppendChild(raw, &html.Node{Type: html.RawNode, Data: "<b>bold & </i>"})
+	want := `<div xmlns="http://www.w3.org/1999/xhtml">&lt;b>bold &amp; &lt;/i></div>`
+	if got := dom.OuterXHTML(raw); got != want {
+		t.Errorf("OuterXHTML() with raw node = %q, want %q", got, want)
+	}
+	decodeXML(t, dom.OuterXHTML(raw))
+
+	// Error node can't be rendered
+	dom.AppendChild(node, &html.Node{Type: html.ErrorNode})
+	for _, mode := range []dom.RenderMode{dom.RenderModeHTML, dom.RenderModeXHTML} {
+		if err := dom.Render(io.Discard, node, dom.RenderOptions{Mode: mode}); err == nil {
+			t.Errorf("Render(mode %d) error = nil, want error", mode)
+		}
+	}
+
+	if got := dom.OuterXHTML(node); got != "" {
+		t.Errorf("OuterXHTML() = %q, want empty", got)
+	}
+}
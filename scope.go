@@ -0,0 +1,287 @@
+package dom
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// scopedSelector is a complex selector which has been split into its
+// compound selectors, so :scope can be matched against a specific node.
+type scopedSelector []scopedCompound
+
+// scopedCompound is a compound selector and the combinator before it.
+type scopedCompound struct {
+	combinator byte
+	matcher    cascadia.Sel
+	scope      bool
+}
+
+// match reports whether node matches the compound selector. If scope is
+// nil, :scope matches the root element of the document.
+func (c scopedCompound) match(node, scope *html.Node) bool {
+	if c.scope {
+		if scope == nil {
+			if node.Type != html.ElementNode || node.Parent == nil || node.Parent.Type != html.DocumentNode {
+				return false
+			}
+		} else if node != scope {
+			return false
+		}
+	} else if node.Type != html.ElementNode {
+		return false
+	}
+
+	return c.matcher == nil || c.matcher.Match(node)
+}
+
+// match reports whether node matches the complex selector.
+func (s scopedSelector) match(node, scope *html.Node) bool {
+	return s.matchAt(len(s)-1, node, scope)
+}
+
+func (s scopedSelector) matchAt(i int, node, scope *html.Node) bool {
+	if !s[i].match(node, scope) {
+		return false
+	}
+
+	if i == 0 {
+		return true
+	}
+
+	switch s[i].combinator {
+	case '>':
+		return node.Parent != nil && s.matchAt(i-1, node.Parent, scope)
+
+	case '+':
+		prev := PreviousElementSibling(node)
+		return prev != nil && s.matchAt(i-1, prev, scope)
+
+	case '~':
+		for prev := PreviousElementSibling(node); prev != nil; prev = PreviousElementSibling(prev) {
+			if s.matchAt(i-1, prev, scope) {
+				return true
+			}
+		}
+
+	default:
+		for parent := range Ancestors(node) {
+			if s.matchAt(i-1, parent, scope) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasScopePseudoClass reports whether selectors might use :scope.
+func hasScopePseudoClass(selectors string) bool {
+	return strings.Contains(strings.ToLower(selectors), ":scope")
+}
+
+// parseScopedSelectors parses a group of selectors which use :scope.
+// Since the selector parser doesn't know :scope, each compound selector
+// is parsed on its own while :scope itself is handled by scopedCompound.
+//
+// If relative is true, selectors which don't use :scope are relative to
+// the scope, i.e. `p` means `:scope p` and `> p` means `:scope > p`.
+func parseScopedSelectors(selectors string, relative bool) ([]scopedSelector, error) {
+	type rawCompound struct {
+		combinator byte
+		text       string
+		scope      bool
+	}
+
+	var (
+		groups     [][]rawCompound
+		current    []rawCompound
+		compound   strings.Builder
+		validation strings.Builder
+		combinator byte
+		hasScope   bool
+		quote      byte
+		depth      int
+	)
+
+	write := func(s string) {
+		compound.WriteString(s)
+		validation.WriteString(s)
+	}
+
+	flush := func() {
+		if compound.Len() > 0 || hasScope {
+			current = append(current, rawCompound{
+				combinator: combinator,
+				text:       compound.String(),
+				scope:      hasScope,
+			})
+			compound.Reset()
+			combinator, hasScope = 0, false
+		}
+	}
+
+	for i := 0; i < len(selectors); i++ {
+		c := selectors[i]
+		switch {
+		case c == '\\' && i+1 < len(selectors):
+			write(selectors[i : i+2])
+			i++
+			continue
+
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			write(selectors[i : i+1])
+			continue
+
+		case c == '"' || c == '\'':
+			quote = c
+			write(selectors[i : i+1])
+			continue
+
+		// :scope is replaced by :empty which has the same length, so the
+		// whole group can be validated with the proper error offset.
+		case isScopePseudoClass(selectors, i):
+			if depth > 0 {
+				return nil, &SelectorError{
+					Selector: selectors,
+					Offset:   i,
+					Err:      errors.New(":scope is not supported inside functional pseudo-classes"),
+				}
+			}
+
+			validation.WriteString(":empty")
+			hasScope = true
+			i += len(":scope") - 1
+			continue
+
+		case c == '(' || c == '[':
+			depth++
+			write(selectors[i : i+1])
+			continue
+
+		case c == ')' || c == ']':
+			depth--
+			write(selectors[i : i+1])
+			continue
+
+		case depth > 0:
+			write(selectors[i : i+1])
+			continue
+		}
+
+		switch c {
+		case ',':
+			flush()
+			groups = append(groups, current)
+			current, combinator = nil, 0
+			validation.WriteByte(c)
+
+		case ' ', '\t', '\n', '\r', '\f':
+			flush()
+			if len(current) > 0 && combinator == 0 {
+				combinator = ' '
+			}
+			validation.WriteByte(c)
+
+		case '>', '+', '~':
+			flush()
+
+			// Leading combinator in relative selector is validated as if
+			// it's a whitespace, which keeps the offsets intact.
+			if relative && len(current) == 0 && combinator == 0 {
+				validation.WriteByte(' ')
+			} else {
+				validation.WriteByte(c)
+			}
+			combinator = c
+
+		default:
+			write(selectors[i : i+1])
+		}
+	}
+
+	flush()
+	groups = append(groups, current)
+
+	if _, err := cascadia.ParseGroup(validation.String()); err != nil {
+		return nil, &SelectorError{
+			Selector: selectors,
+			Offset:   selectorErrorOffset(validation.String(), err),
+			Err:      err,
+		}
+	}
+
+	var result []scopedSelector
+	for _, group := range groups {
+		anchored := false
+		for _, raw := range group {
+			anchored = anchored || raw.scope
+		}
+
+		var sel scopedSelector
+		if relative && !anchored {
+			if group[0].combinator == 0 {
+				group[0].combinator = ' '
+			}
+			sel = append(sel, scopedCompound{scope: true})
+		} else if group[0].combinator != 0 {
+			return nil, &SelectorError{
+				Selector: selectors,
+				Err:      errors.New("relative selector can't use :scope explicitly"),
+			}
+		}
+
+		for _, raw := range group {
+			part := scopedCompound{combinator: raw.combinator, scope: raw.scope}
+			if raw.text != "" {
+				matcher, err := cascadia.Parse(raw.text)
+				if err != nil {
+					return nil, &SelectorError{Selector: selectors, Err: err}
+				}
+				part.matcher = matcher
+			}
+			sel = append(sel, part)
+		}
+		result = append(result, sel)
+	}
+
+	return result, nil
+}
+
+// hasSiblingScope reports whether any of the selectors looks for
+// siblings of the scope, e.g. `:scope + p` or `:scope ~ p`.
+func hasSiblingScope(selectors []scopedSelector) bool {
+	for _, sel := range selectors {
+		for i := 0; i < len(sel)-1; i++ {
+			if sel[i].scope && (sel[i+1].combinator == '+' || sel[i+1].combinator == '~') {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isScopePseudoClass reports whether :scope starts at s[i].
+func isScopePseudoClass(s string, i int) bool {
+	end := i + len(":scope")
+	if s[i] != ':' || end > len(s) || (i > 0 && s[i-1] == ':') {
+		return false
+	}
+
+	if !strings.EqualFold(s[i+1:end], "scope") {
+		return false
+	}
+
+	if end == len(s) {
+		return true
+	}
+
+	c := s[end]
+	return !(c == '-' || c == '_' || c == '(' || c == '\\' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
+}
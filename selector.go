@@ -0,0 +1,432 @@
+package dom
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// DefaultSelectorCacheSize is the number of compiled selectors kept
+// in the cache used by QuerySelector() and its variants.
+const DefaultSelectorCacheSize = 256
+
+var (
+	rxSelectorLeftOver = regexp.MustCompile(`(\d+) bytes left over$`)
+	selectorCache      = newSelectorLRU(DefaultSelectorCacheSize)
+)
+
+// SelectorError is returned when a group of CSS selectors can't be parsed.
+type SelectorError struct {
+	// Selector is the selector text that failed to parse.
+	Selector string
+
+	// Offset is the byte offset in Selector where parsing stopped.
+	// Since the underlying parser doesn't report its position for
+	// every error, this offset is the end of the longest valid
+	// prefix of Selector, which is usually where the mistake is.
+	Offset int
+
+	// Err is the error reported by the selector parser.
+	Err error
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("invalid selector %q at offset %d: %v", e.Selector, e.Offset, e.Err)
+}
+
+// Unwrap returns the error reported by the selector parser.
+func (e *SelectorError) Unwrap() error {
+	return e.Err
+}
+
+// Selector is a compiled group of CSS selectors which can be reused
+// to query several documents without parsing the selectors again.
+// It's safe for concurrent use.
+type Selector struct {
+	text     string
+	relative bool
+	siblings bool
+	group    cascadia.SelectorGroup
+	scoped   []scopedSelector
+}
+
+// CompileSelector parses a group of selectors into a reusable Selector.
+// It returns a *SelectorError when the selectors can't be parsed.
+// The compiled selectors are cached, so compiling the same selectors
+// again is cheap.
+func CompileSelector(selectors string) (*Selector, error) {
+	return compileSelector(selectors, false)
+}
+
+// CompileScopedSelector parses a group of relative selectors into
+// a reusable Selector. When it's used to query a node, :scope matches
+// the node and selectors which don't use :scope are relative to it,
+// so `p` means `:scope p` and leading combinators like `> p`, `+ h2`
+// or `~ ul` are allowed.
+func CompileScopedSelector(selectors string) (*Selector, error) {
+	return compileSelector(selectors, true)
+}
+
+func compileSelector(selectors string, relative bool) (*Selector, error) {
+	key := selectorKey{selectors: selectors, relative: relative}
+	if sel, ok := selectorCache.get(key); ok {
+		return sel, nil
+	}
+
+	sel := &Selector{text: selectors, relative: relative}
+	if relative || hasScopePseudoClass(selectors) {
+		scoped, err := parseScopedSelectors(selectors, relative)
+		if err != nil {
+			return nil, err
+		}
+		sel.scoped = scoped
+		sel.siblings = relative && hasSiblingScope(scoped)
+	} else {
+		group, err := cascadia.ParseGroup(selectors)
+		if err != nil {
+			return nil, &SelectorError{
+				Selector: selectors,
+				Offset:   selectorErrorOffset(selectors, err),
+				Err:      err,
+			}
+		}
+		sel.group = group
+	}
+
+	selectorCache.put(key, sel)
+	return sel, nil
+}
+
+// MustCompileSelector works like CompileSelector() except it panics
+// when the selectors can't be parsed.
+func MustCompileSelector(selectors string) *Selector {
+	sel, err := CompileSelector(selectors)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// Match returns true if the node matches the selector. Since there
+// is no scoping node, :scope matches the root element.
+func (s *Selector) Match(node *html.Node) bool {
+	return node != nil && s.matchScope(node, nil)
+}
+
+// First returns the first descendant of doc that matches the selector.
+// :scope matches doc itself. For selector compiled by
+// CompileScopedSelector(), the following siblings of doc and their
+// descendants are checked as well.
+func (s *Selector) First(doc *html.Node) *html.Node {
+	if nodes := s.query(doc, true); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+// All returns all descendants of doc that match the selector.
+// :scope matches doc itself. For selector compiled by
+// CompileScopedSelector(), the following siblings of doc and their
+// descendants are checked as well.
+func (s *Selector) All(doc *html.Node) []*html.Node {
+	return s.query(doc, false)
+}
+
+// query looks for nodes that match the selector with doc as the scope.
+// If first is true, it stops after the first match.
+func (s *Selector) query(doc *html.Node, first bool) []*html.Node {
+	var result []*html.Node
+	search := func(root *html.Node, includeRoot bool) bool {
+		for w := newTreeWalk(root, includeRoot); w.next(false); {
+			if w.current.Type == html.ElementNode && s.matchScope(w.current, doc) {
+				result = append(result, w.current)
+				if first {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if search(doc, false) || !s.siblings {
+		return result
+	}
+
+	for sibling := range FollowingSiblings(doc) {
+		if search(sibling, true) {
+			break
+		}
+	}
+
+	return result
+}
+
+// matchScope reports whether node matches the selector, where :scope
+// matches the scope node.
+func (s *Selector) matchScope(node, scope *html.Node) bool {
+	if s.scoped == nil {
+		return s.group.Match(node)
+	}
+
+	for _, sel := range s.scoped {
+		if sel.match(node, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the selector text used to compile the selector.
+func (s *Selector) String() string {
+	return s.text
+}
+
+// SetSelectorCacheSize changes the number of compiled selectors kept in
+// the cache used by QuerySelector() and its variants. Size zero or less
+// disables the cache.
+func SetSelectorCacheSize(size int) {
+	selectorCache.resize(size)
+}
+
+// QuerySelectorAllErr works like QuerySelectorAll() except it returns
+// a *SelectorError when the selectors can't be parsed.
+func QuerySelectorAllErr(doc *html.Node, selectors string) ([]*html.Node, error) {
+	sel, err := CompileSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return sel.All(doc), nil
+}
+
+// QuerySelectorErr works like QuerySelector() except it returns
+// a *SelectorError when the selectors can't be parsed.
+func QuerySelectorErr(doc *html.Node, selectors string) (*html.Node, error) {
+	sel, err := CompileSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return sel.First(doc), nil
+}
+
+// QuerySelectorAllScoped returns all elements that match the specified
+// group of relative selectors, where :scope matches node. Selectors which
+// don't use :scope are relative to node, so `div p` only matches <p> whose
+// <div> ancestor is inside node, and leading combinators like `> p`,
+// `+ h2` or `~ ul` are allowed. If the selectors are invalid, it returns
+// nil. Use QuerySelectorAllScopedErr() to get the parse error.
+func QuerySelectorAllScoped(node *html.Node, selectors string) []*html.Node {
+	nodes, _ := QuerySelectorAllScopedErr(node, selectors)
+	return nodes
+}
+
+// QuerySelectorAllScopedErr works like QuerySelectorAllScoped() except it
+// returns a *SelectorError when the selectors can't be parsed.
+func QuerySelectorAllScopedErr(node *html.Node, selectors string) ([]*html.Node, error) {
+	sel, err := CompileScopedSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return sel.All(node), nil
+}
+
+// QuerySelectorScoped works like QuerySelectorAllScoped() except it
+// only returns the first matching element.
+func QuerySelectorScoped(node *html.Node, selectors string) *html.Node {
+	found, _ := QuerySelectorScopedErr(node, selectors)
+	return found
+}
+
+// QuerySelectorScopedErr works like QuerySelectorScoped() except it
+// returns a *SelectorError when the selectors can't be parsed.
+func QuerySelectorScopedErr(node *html.Node, selectors string) (*html.Node, error) {
+	sel, err := CompileScopedSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	return sel.First(node), nil
+}
+
+// MustQuerySelectorAll works like QuerySelectorAll() except it panics
+// when the selectors can't be parsed.
+func MustQuerySelectorAll(doc *html.Node, selectors string) []*html.Node {
+	nodes, err := QuerySelectorAllErr(doc, selectors)
+	if err != nil {
+		panic(err)
+	}
+	return nodes
+}
+
+// MustQuerySelector works like QuerySelector() except it panics
+// when the selectors can't be parsed.
+func MustQuerySelector(doc *html.Node, selectors string) *html.Node {
+	node, err := QuerySelectorErr(doc, selectors)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+// ValidateSelectors checks whether each of the specified group of
+// selectors can be parsed. It returns a *SelectorError for the first
+// one that can't, or nil if all of them are valid.
+func ValidateSelectors(selectors ...string) error {
+	for _, s := range selectors {
+		if _, err := CompileSelector(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the node would be selected by the specified
+// group of selectors, where :scope matches the node itself. If the
+// selectors are invalid, it returns false. Use MatchesErr() to get
+// the parse error.
+func Matches(node *html.Node, selectors string) bool {
+	matched, _ := MatchesErr(node, selectors)
+	return matched
+}
+
+// MatchesErr works like Matches() except it returns a *SelectorError
+// when the selectors can't be parsed.
+func MatchesErr(node *html.Node, selectors string) (bool, error) {
+	sel, err := CompileSelector(selectors)
+	if err != nil {
+		return false, err
+	}
+
+	return node != nil && sel.matchScope(node, node), nil
+}
+
+// Closest traverses the node and its ancestors until it finds an element
+// that matches the specified group of selectors, where :scope matches the
+// starting node. It returns nil if there is no such element or if the
+// selectors are invalid. Use ClosestErr() to get the parse error.
+func Closest(node *html.Node, selectors string) *html.Node {
+	closest, _ := ClosestErr(node, selectors)
+	return closest
+}
+
+// ClosestErr works like Closest() except it returns a *SelectorError
+// when the selectors can't be parsed.
+func ClosestErr(node *html.Node, selectors string) (*html.Node, error) {
+	sel, err := CompileSelector(selectors)
+	if err != nil {
+		return nil, err
+	}
+
+	for ancestor := node; ancestor != nil; ancestor = ancestor.Parent {
+		if ancestor.Type == html.ElementNode && sel.matchScope(ancestor, node) {
+			return ancestor, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// selectorErrorOffset guesses the position where parsing selectors
+// failed. When the parser stopped early, the offset is taken from its
+// error message. Otherwise it's the end of the longest valid prefix.
+func selectorErrorOffset(selectors string, err error) int {
+	if match := rxSelectorLeftOver.FindStringSubmatch(err.Error()); match != nil {
+		if leftOver, convErr := strconv.Atoi(match[1]); convErr == nil {
+			return len(selectors) - leftOver
+		}
+	}
+
+	for i := len(selectors) - 1; i > 0; i-- {
+		if _, err := cascadia.ParseGroup(selectors[:i]); err == nil {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// selectorKey identifies a compiled selector in the cache.
+type selectorKey struct {
+	selectors string
+	relative  bool
+}
+
+// selectorLRU is a concurrency-safe LRU cache of compiled selectors.
+type selectorLRU struct {
+	mu      sync.Mutex
+	size    int
+	items   *list.List
+	entries map[selectorKey]*list.Element
+}
+
+type selectorLRUItem struct {
+	key selectorKey
+	sel *Selector
+}
+
+func newSelectorLRU(size int) *selectorLRU {
+	return &selectorLRU{
+		size:    size,
+		items:   list.New(),
+		entries: make(map[selectorKey]*list.Element),
+	}
+}
+
+func (c *selectorLRU) get(key selectorKey) (*Selector, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, exist := c.entries[key]
+	if !exist {
+		return nil, false
+	}
+
+	c.items.MoveToFront(elem)
+	return elem.Value.(*selectorLRUItem).sel, true
+}
+
+func (c *selectorLRU) put(key selectorKey, sel *Selector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+
+	if elem, exist := c.entries[key]; exist {
+		elem.Value.(*selectorLRUItem).sel = sel
+		c.items.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.items.PushFront(&selectorLRUItem{
+		key: key,
+		sel: sel,
+	})
+	c.evict()
+}
+
+func (c *selectorLRU) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.size = size
+	c.evict()
+}
+
+// evict removes the least recently used selectors until the cache
+// fits its size. The caller must hold the lock.
+func (c *selectorLRU) evict() {
+	for c.items.Len() > 0 && c.items.Len() > c.size {
+		oldest := c.items.Back()
+		c.items.Remove(oldest)
+		delete(c.entries, oldest.Value.(*selectorLRUItem).key)
+	}
+}
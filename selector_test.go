@@ -0,0 +1,479 @@
+package dom_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestQuerySelectorErr(t *testing.T) {
+	htmlSource := `<div>
+		<p id="paragraph" class="class-a"></p>
+		<span class="class-a"></span>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("QuerySelectorErr(), failed to parse: %v", err)
+	}
+
+	tests := []struct {
+		selectors string
+		count     int
+		wantErr   bool
+	}{
+		{selectors: "p", count: 1},
+		{selectors: ".class-a", count: 2},
+		{selectors: "#unknown-id", count: 0},
+		{selectors: "p[", wantErr: true},
+		{selectors: "div:unknown", wantErr: true},
+		{selectors: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selectors, func(t *testing.T) {
+			nodes, err := dom.QuerySelectorAllErr(doc, tt.selectors)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("QuerySelectorAllErr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := len(nodes); got != tt.count {
+				t.Errorf("QuerySelectorAllErr() = %v, want %v", got, tt.count)
+			}
+
+			node, err := dom.QuerySelectorErr(doc, tt.selectors)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("QuerySelectorErr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if found := node != nil; found != (tt.count > 0) {
+				t.Errorf("QuerySelectorErr() found = %v, want %v", found, tt.count > 0)
+			}
+		})
+	}
+}
+
+func TestSelectorError(t *testing.T) {
+	tests := []struct {
+		selectors string
+		offset    int
+	}{
+		{selectors: "", offset: 0},
+		{selectors: "#", offset: 0},
+		{selectors: "p[", offset: 1},
+		{selectors: "div:unknown", offset: 3},
+		{selectors: "div)", offset: 3},
+		{selectors: "div.class-a, p[id=", offset: 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selectors, func(t *testing.T) {
+			err := dom.ValidateSelectors(tt.selectors)
+
+			var selErr *dom.SelectorError
+			if !errors.As(err, &selErr) {
+				t.Fatalf("ValidateSelectors() error = %v, want *SelectorError", err)
+			}
+
+			if selErr.Selector != tt.selectors {
+				t.Errorf("SelectorError.Selector = %q, want %q", selErr.Selector, tt.selectors)
+			}
+
+			if selErr.Offset != tt.offset {
+				t.Errorf("SelectorError.Offset = %v, want %v", selErr.Offset, tt.offset)
+			}
+
+			if selErr.Unwrap() == nil {
+				t.Errorf("SelectorError.Unwrap() = nil, want parser error")
+			}
+		})
+	}
+
+	t.Run("all valid", func(t *testing.T) {
+		if err := dom.ValidateSelectors("p", "div > span", ".a, .b"); err != nil {
+			t.Errorf("ValidateSelectors() = %v, want nil", err)
+		}
+	})
+}
+
+func TestMustQuerySelector(t *testing.T) {
+	doc, err := parseHTMLSource(`<div><p></p></div>`)
+	if err != nil {
+		t.Errorf("MustQuerySelector(), failed to parse: %v", err)
+	}
+
+	if node := dom.MustQuerySelector(doc, "p"); dom.TagName(node) != "p" {
+		t.Errorf("MustQuerySelector() = %v, want p", dom.TagName(node))
+	}
+
+	if nodes := dom.MustQuerySelectorAll(doc, "div, p"); len(nodes) != 2 {
+		t.Errorf("MustQuerySelectorAll() = %v, want 2", len(nodes))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MustQuerySelector() didn't panic on invalid selector")
+		}
+	}()
+	dom.MustQuerySelector(doc, "p[")
+}
+
+func TestCompileSelector(t *testing.T) {
+	htmlSource := `<div>
+		<p class="class-a"></p>
+		<p class="class-b"></p>
+		<span class="class-a"></span>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("CompileSelector(), failed to parse: %v", err)
+	}
+
+	sel, err := dom.CompileSelector("p.class-a, span")
+	if err != nil {
+		t.Fatalf("CompileSelector() error = %v", err)
+	}
+
+	if got := sel.String(); got != "p.class-a, span" {
+		t.Errorf("Selector.String() = %v, want %v", got, "p.class-a, span")
+	}
+
+	all := sel.All(doc)
+	if len(all) != 2 {
+		t.Fatalf("Selector.All() = %v, want 2", len(all))
+	}
+
+	if first := sel.First(doc); first != all[0] {
+		t.Errorf("Selector.First() = %v, want %v", dom.OuterHTML(first), dom.OuterHTML(all[0]))
+	}
+
+	for _, node := range dom.GetElementsByTagName(doc, "*") {
+		want := dom.IncludeNode(all, node)
+		if got := sel.Match(node); got != want {
+			t.Errorf("Selector.Match(%s) = %v, want %v", dom.OuterHTML(node), got, want)
+		}
+	}
+
+	if _, err := dom.CompileSelector("p["); err == nil {
+		t.Errorf("CompileSelector() error = nil, want error")
+	}
+}
+
+func TestSelectorCache(t *testing.T) {
+	defer dom.SetSelectorCacheSize(dom.DefaultSelectorCacheSize)
+
+	doc, err := parseHTMLSource(`<div><p class="a"></p><p class="b"></p></div>`)
+	if err != nil {
+		t.Errorf("SelectorCache, failed to parse: %v", err)
+	}
+
+	for _, size := range []int{0, 1, 2, dom.DefaultSelectorCacheSize} {
+		dom.SetSelectorCacheSize(size)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				selectors := []string{"p", ".a", ".b", "div > p", "p.a, p.b"}
+				counts := []int{2, 1, 1, 2, 2}
+				for j := 0; j < 50; j++ {
+					k := (i + j) % len(selectors)
+					if got := len(dom.QuerySelectorAll(doc, selectors[k])); got != counts[k] {
+						t.Errorf("QuerySelectorAll(%q) = %v, want %v", selectors[k], got, counts[k])
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+var benchmarkSelectors = []string{
+	"p", "div > p", ".class-a", "#heading",
+	"p.class-a, span.class-b", "div p:not(.class-c)",
+}
+
+func benchmarkQuerySelectorAll(b *testing.B, cacheSize int) {
+	defer dom.SetSelectorCacheSize(dom.DefaultSelectorCacheSize)
+	dom.SetSelectorCacheSize(cacheSize)
+
+	doc, err := parseHTMLSource(`<div>
+		<h1 id="heading"></h1>
+		<p class="class-a"></p>
+		<p class="class-b"></p>
+		<div><p class="class-c"></p><span class="class-b"></span></div>
+	</div>`)
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dom.QuerySelectorAll(doc, benchmarkSelectors[i%len(benchmarkSelectors)])
+	}
+}
+
+func BenchmarkQuerySelectorAllNoCache(b *testing.B) {
+	benchmarkQuerySelectorAll(b, 0)
+}
+
+func BenchmarkQuerySelectorAllCache(b *testing.B) {
+	benchmarkQuerySelectorAll(b, dom.DefaultSelectorCacheSize)
+}
+
+func BenchmarkSelectorAll(b *testing.B) {
+	doc, err := parseHTMLSource(`<div><p class="class-a"></p><p></p></div>`)
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+
+	sel := dom.MustCompileSelector("div p:not(.class-c)")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sel.All(doc)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	htmlSource := `<div id="main" class="content">
+		<ul class="list">
+			<li id="first" class="item">One</li>
+			<li id="second" class="item active">Two</li>
+		</ul>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("Matches(), failed to parse: %v", err)
+	}
+
+	second := dom.GetElementByID(doc, "second")
+	tests := []struct {
+		selectors string
+		want      bool
+		wantErr   bool
+	}{
+		{selectors: "li", want: true},
+		{selectors: "li.active", want: true},
+		{selectors: ".content li", want: true},
+		{selectors: "ul > li + li", want: true},
+		{selectors: "#first ~ li", want: true},
+		{selectors: "p, .item", want: true},
+		{selectors: "#first", want: false},
+		{selectors: "div > li", want: false},
+		{selectors: ":scope", want: true},
+		{selectors: "li:scope.active", want: true},
+		{selectors: "ul > :scope", want: true},
+		{selectors: ":scope > li", want: false},
+		{selectors: "p:not(.x), :SCOPE", want: true},
+		{selectors: "li[title=':scope']", want: false},
+		{selectors: "li[", wantErr: true},
+		{selectors: ":not(:scope)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selectors, func(t *testing.T) {
+			got, err := dom.MatchesErr(second, tt.selectors)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MatchesErr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("MatchesErr() = %v, want %v", got, tt.want)
+			}
+
+			if got := dom.Matches(second, tt.selectors); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClosest(t *testing.T) {
+	htmlSource := `<div id="outer" class="box">
+		<div id="inner" class="box">
+			<ul id="list">
+				<li id="item"><span id="text">One</span></li>
+			</ul>
+		</div>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("Closest(), failed to parse: %v", err)
+	}
+
+	text := dom.GetElementByID(doc, "text")
+	tests := map[string]string{
+		"span":               "text",
+		"li":                 "item",
+		".box":               "inner",
+		"#outer":             "outer",
+		"div > ul":           "list",
+		".box .box":          "inner",
+		"body > .box":        "outer",
+		":scope":             "text",
+		"ul :scope":          "text",
+		"table":              "",
+		"li:not(#item), div": "inner",
+	}
+
+	for selectors, want := range tests {
+		t.Run(selectors, func(t *testing.T) {
+			closest, err := dom.ClosestErr(text, selectors)
+			if err != nil {
+				t.Fatalf("ClosestErr() error = %v", err)
+			}
+
+			got := ""
+			if closest != nil {
+				got = dom.ID(closest)
+			}
+
+			if got != want {
+				t.Errorf("ClosestErr() = %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("invalid selector", func(t *testing.T) {
+		if got := dom.Closest(text, "div["); got != nil {
+			t.Errorf("Closest() = %v, want nil", dom.OuterHTML(got))
+		}
+
+		for _, selectors := range []string{"div[", "li:has(> :scope)"} {
+			var selErr *dom.SelectorError
+			if _, err := dom.ClosestErr(text, selectors); !errors.As(err, &selErr) {
+				t.Errorf("ClosestErr(%q) error = %v, want *SelectorError", selectors, err)
+			}
+		}
+	})
+}
+
+func TestQuerySelectorScope(t *testing.T) {
+	htmlSource := `<div id="main">
+		<p id="direct"><span><b id="nested"></b></span></p>
+		<section><p id="deep"></p></section>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("QuerySelectorAll(), failed to parse: %v", err)
+	}
+
+	main := dom.GetElementByID(doc, "main")
+	tests := map[string]int{
+		":scope > p":       1,
+		":scope p":         2,
+		":scope > * > *":   2,
+		":scope section p": 1,
+		"div:scope > *":    2,
+	}
+
+	for selectors, count := range tests {
+		t.Run(selectors, func(t *testing.T) {
+			if got := len(dom.QuerySelectorAll(main, selectors)); got != count {
+				t.Errorf("QuerySelectorAll() = %v, want %v", got, count)
+			}
+		})
+	}
+}
+
+func TestQuerySelectorAllScoped(t *testing.T) {
+	htmlSource := `<div id="outer">
+		<div id="main">
+			<p id="direct"><span><b id="nested"></b></span></p>
+			<section><p id="deep"></p></section>
+		</div>
+		<h2 id="title"></h2>
+		<ul id="list-a"><li><p id="in-list"></p></li></ul>
+		<ul id="list-b"></ul>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("QuerySelectorAllScoped(), failed to parse: %v", err)
+	}
+
+	main := dom.GetElementByID(doc, "main")
+	tests := map[string]string{
+		"p":                  "direct,deep",
+		"> p":                "direct",
+		"div p":              "",
+		"section p":          "deep",
+		"> * > *":            "deep",
+		"> p b, > section p": "nested,deep",
+		":scope > p":         "direct",
+		"div:scope > *":      "direct",
+		"+ h2":               "title",
+		"~ ul":               "list-a,list-b",
+		"+ h2 + ul p":        "in-list",
+		"~ ul p, > p":        "direct,in-list",
+		":scope + h2":        "title",
+		"b":                  "nested",
+	}
+
+	for selectors, want := range tests {
+		t.Run(selectors, func(t *testing.T) {
+			nodes, err := dom.QuerySelectorAllScopedErr(main, selectors)
+			if err != nil {
+				t.Fatalf("QuerySelectorAllScopedErr() error = %v", err)
+			}
+
+			var ids []string
+			for _, node := range nodes {
+				if id := dom.ID(node); id != "" {
+					ids = append(ids, id)
+				}
+			}
+
+			if got := strings.Join(ids, ","); got != want {
+				t.Errorf("QuerySelectorAllScopedErr() = %v, want %v", got, want)
+			}
+
+			first := dom.QuerySelectorScoped(main, selectors)
+			if len(nodes) > 0 && first != nodes[0] {
+				t.Errorf("QuerySelectorScoped() = %v, want %v", dom.OuterHTML(first), dom.OuterHTML(nodes[0]))
+			}
+		})
+	}
+
+	// Unlike QuerySelectorAll, ancestors above node are not matched
+	if got := len(dom.QuerySelectorAll(main, "div p")); got != 2 {
+		t.Errorf("QuerySelectorAll() = %v, want 2", got)
+	}
+
+	for _, selectors := range []string{"> > p", ">", "p[", "> :scope p"} {
+		var selErr *dom.SelectorError
+		if _, err := dom.QuerySelectorAllScopedErr(main, selectors); !errors.As(err, &selErr) {
+			t.Errorf("QuerySelectorAllScopedErr(%q) error = %v, want *SelectorError", selectors, err)
+		}
+
+		if got := dom.QuerySelectorAllScoped(main, selectors); got != nil {
+			t.Errorf("QuerySelectorAllScoped(%q) = %v, want nil", selectors, len(got))
+		}
+	}
+
+	// Like browsers, non-scoped query doesn't look outside of node
+	for _, selectors := range []string{":scope + h2", ":scope ~ ul", ":scope ~ ul p"} {
+		if got := dom.QuerySelectorAll(main, selectors); len(got) != 0 {
+			t.Errorf("QuerySelectorAll(%q) = %v, want 0", selectors, len(got))
+		}
+
+		if got := dom.QuerySelector(main, selectors); got != nil {
+			t.Errorf("QuerySelector(%q) = %v, want nil", selectors, dom.OuterHTML(got))
+		}
+	}
+
+	// Leading combinator is still invalid in non-scoped query
+	if _, err := dom.QuerySelectorAllErr(main, "> p"); err == nil {
+		t.Errorf("QuerySelectorAllErr() error = nil, want error")
+	}
+}
@@ -0,0 +1,166 @@
+package dom
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/html"
+	"golang.org/x/text/transform"
+)
+
+// streamSniffSize is the size of the prefix which is used to detect the
+// encoding in ParseStream. It's much larger than the 1024 bytes used for
+// <meta> prescan since the charset detector needs enough text to guess.
+const streamSniffSize = 64 * 1024
+
+// ParseStream works like ParseWithOptions, but it doesn't read the whole
+// document into memory before parsing. The encoding is detected from the
+// first 64 KiB of the document, then the rest is decoded and fed into the
+// HTML parser as it's read. This way the memory usage is roughly the size
+// of the parsed tree, which is useful for multi-megabyte pages.
+//
+// Since the charset detector only sees the prefix, the guessed encoding
+// may differ from ParseWithOptions for documents without declared encoding.
+func ParseStream(r io.Reader, opts ParseOptions) (*html.Node, *ParseInfo, error) {
+	// Tracking positions needs the whole document
+	if opts.TrackPositions {
+		return ParseWithOptions(r, opts)
+	}
+
+	var limited *limitedReader
+	if opts.MaxBytes > 0 {
+		limited = &limitedReader{r: r, remaining: opts.MaxBytes, opts: opts}
+		r = limited
+	}
+
+	// Detect page encoding from the prefix
+	br := bufio.NewReaderSize(r, streamSniffSize)
+	prefix, err := br.Peek(streamSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, nil, err
+	}
+
+	pageEncoding, info, content := detectEncoding(prefix, opts.ContentType)
+	if _, err = br.Discard(len(prefix) - len(content)); err != nil {
+		return nil, nil, err
+	}
+
+	// Decode and parse the content while reading it
+	pattern, step := replacementPattern(info.Charset)
+	source := newPatternCounter(br, pattern, step)
+
+	pattern, step = replacementPattern("utf-8")
+	decoded := newPatternCounter(transform.NewReader(source, pageEncoding.NewDecoder()), pattern, step)
+
+	doc, err := html.Parse(newTreeLimiter(normalizeTextEncoding(decoded, opts.Normalize), opts))
+	if err != nil {
+		return nil, nil, err
+	}
+	info.HasReplacement = decoded.count > source.count
+
+	pruned, err := limitTree(doc, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.Truncated = pruned || (limited != nil && limited.truncated)
+
+	return doc, info, nil
+}
+
+// limitedReader reads from r until the remaining bytes is exhausted.
+// Once there are more bytes to read, it returns ErrTooLarge, or io.EOF
+// if opts.Truncate is true.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	opts      ParseOptions
+	truncated bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		// Check whether there are more bytes behind the limit
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		switch {
+		case n == 0:
+			return 0, err
+		case l.opts.Truncate:
+			l.truncated = true
+			return 0, io.EOF
+		default:
+			return 0, fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, l.opts.MaxBytes)
+		}
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// patternCounter counts the occurrences of a byte pattern in the stream
+// which passes through it, including the ones across the read boundaries.
+// The pattern is only matched at offsets which are multiple of step, e.g.
+// at the boundaries of UTF-16 code units.
+type patternCounter struct {
+	r        io.Reader
+	pattern  []byte
+	step     int
+	tail     []byte
+	boundary []byte
+	offset   int64
+	count    int
+}
+
+func newPatternCounter(r io.Reader, pattern []byte, step int) *patternCounter {
+	return &patternCounter{r: r, pattern: pattern, step: step}
+}
+
+func (c *patternCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n == 0 || len(c.pattern) == 0 {
+		return n, err
+	}
+
+	// The matches which start in the tail of previous read are looked up
+	// in the tail joined with the head of this read, so the data itself
+	// is never copied.
+	keep := len(c.pattern) - 1
+	data := p[:n]
+	c.boundary = append(append(c.boundary[:0], c.tail...), data[:min(n, keep)]...)
+	c.scan(c.boundary, c.offset, len(c.tail))
+	c.scan(data, c.offset+int64(len(c.tail)), n)
+
+	// Keep the last bytes which may be the start of a match
+	seen := c.boundary
+	if n >= keep {
+		seen = data
+	}
+
+	keep = min(keep, len(seen))
+	c.offset += int64(len(c.tail) + n - keep)
+	c.tail = append(c.tail[:0], seen[len(seen)-keep:]...)
+	return n, err
+}
+
+// scan counts the matches in buf which start before limit. The offset is
+// the position of buf in the stream.
+func (c *patternCounter) scan(buf []byte, offset int64, limit int) {
+	for i := 0; i < limit; i++ {
+		idx := bytes.Index(buf[i:], c.pattern)
+		if idx < 0 {
+			break
+		}
+
+		i += idx
+		if i < limit && (offset+int64(i))%int64(c.step) == 0 {
+			c.count++
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package dom_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestParseStream(t *testing.T) {
+	utf8BOM := []byte{0xEF, 0xBB, 0xBF}
+	utf16LE := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	longText := strings.Repeat("Lorem ipsum dolor sit amet. ", 10000)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		contentType string
+	}{
+		{"utf-8 with bom", append(utf8BOM, "<p>Héllo wörld</p>"...), ""},
+		{"utf-16le with bom", encodeString(t, utf16LE, "<p>Héllo wörld</p>"), ""},
+		{"shift_jis", encodeString(t, japanese.ShiftJIS, "<html><body><p>"+japaneseText+"</p></body></html>"), ""},
+		{"windows-1251", encodeString(t, charmap.Windows1251, "<html><body><p>"+russianText+"</p></body></html>"), ""},
+		{"content type", encodeString(t, japanese.EUCJP, "<p>"+japaneseText+"</p>"), "text/html; charset=euc-jp"},
+		{"meta", encodeString(t, charmap.Windows1252, `<meta charset="windows-1252"><p>Café `+longText+"</p>"), ""},
+		{"invalid utf-8", append(utf8BOM, "<p>Broken \xff\xfe text</p>"...), ""},
+		{"existing replacement character", append(utf8BOM, "<p>Already � here</p>"...), ""},
+		{"utf-16 replacement character", encodeString(t, utf16LE, "<p>Already � here</p>"), ""},
+		{"soft hyphen and nfd", []byte("<p>Café hy­phen " + longText + "</p>"), "text/html; charset=utf-8"},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := dom.ParseOptions{ContentType: tt.contentType}
+			want, wantInfo, err := dom.ParseWithOptions(bytes.NewReader(tt.content), opts)
+			if err != nil {
+				t.Fatalf("ParseWithOptions() error = %v", err)
+			}
+
+			// Read one byte at a time to make sure the content is
+			// handled correctly across the read boundaries.
+			got, info, err := dom.ParseStream(iotest.OneByteReader(bytes.NewReader(tt.content)), opts)
+			if err != nil {
+				t.Fatalf("ParseStream() error = %v", err)
+			}
+
+			if dom.OuterHTML(got) != dom.OuterHTML(want) {
+				t.Errorf("ParseStream() = %q, want %q", dom.OuterHTML(got), dom.OuterHTML(want))
+			}
+
+			if *info != *wantInfo {
+				t.Errorf("ParseStream() info = %+v, want %+v", *info, *wantInfo)
+			}
+		})
+	}
+}
+
+func TestParseStreamLimits(t *testing.T) {
+	source := "<p>1</p><p>2</p><p>3</p><p>4</p><p>5</p>"
+
+	tests := []struct {
+		name      string
+		opts      dom.ParseOptions
+		err       error
+		text      string
+		truncated bool
+	}{
+		{"within limits", dom.ParseOptions{MaxBytes: int64(len(source)), MaxNodes: 13}, nil, "12345", false},
+		{"too many bytes", dom.ParseOptions{MaxBytes: 20}, dom.ErrTooLarge, "", false},
+		{"truncate bytes", dom.ParseOptions{MaxBytes: 16, Truncate: true}, nil, "12", true},
+		{"too many nodes", dom.ParseOptions{MaxNodes: 8}, dom.ErrTooLarge, "", false},
+		{"too deep", dom.ParseOptions{MaxDepth: 3}, dom.ErrTooDeep, "", false},
+		{"truncate depth", dom.ParseOptions{MaxDepth: 3, Truncate: true}, nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, info, err := dom.ParseStream(strings.NewReader(source), tt.opts)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("ParseStream() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseStream() error = %v", err)
+			}
+
+			if got := dom.TextContent(doc); got != tt.text {
+				t.Errorf("ParseStream() text = %q, want %q", got, tt.text)
+			}
+
+			if info.Truncated != tt.truncated {
+				t.Errorf("ParseStream() Truncated = %v, want %v", info.Truncated, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestParseStreamReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("<p>Partial"), iotest.ErrReader(readErr))
+
+	if _, _, err := dom.ParseStream(r, dom.ParseOptions{}); !errors.Is(err, readErr) {
+		t.Errorf("ParseStream() error = %v, want %v", err, readErr)
+	}
+}
+
+// benchmarkParsePeakMemory parses a multi-megabyte page and reports the
+// peak heap usage while parsing, on top of the allocations per operation.
+func benchmarkParsePeakMemory(b *testing.B, parse func(io.Reader) error) {
+	paragraph := "<p>" + strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 20) + "</p>\n"
+	source := []byte(`<html><head><meta charset="utf-8"></head><body>` +
+		strings.Repeat(paragraph, 5000) + "</body></html>")
+
+	samples := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	heapSize := func() uint64 {
+		metrics.Read(samples)
+		return samples[0].Value.Uint64()
+	}
+
+	var peak uint64
+	b.ReportAllocs()
+	b.SetBytes(int64(len(source)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		runtime.GC()
+		base := heapSize()
+
+		done := make(chan struct{})
+		sampled := make(chan uint64)
+		go func() {
+			var max uint64
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			for {
+				if size := heapSize(); size > max {
+					max = size
+				}
+
+				select {
+				case <-done:
+					sampled <- max
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		b.StartTimer()
+
+		if err := parse(bytes.NewReader(source)); err != nil {
+			b.Fatalf("failed to parse: %v", err)
+		}
+
+		b.StopTimer()
+		close(done)
+		if max := <-sampled; max > base && max-base > peak {
+			peak = max - base
+		}
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(peak)/(1<<20), "peak-MiB")
+}
+
+func BenchmarkParsePeakMemory(b *testing.B) {
+	b.Run("Buffered", func(b *testing.B) {
+		benchmarkParsePeakMemory(b, func(r io.Reader) error {
+			_, _, err := dom.ParseWithOptions(r, dom.ParseOptions{})
+			return err
+		})
+	})
+
+	b.Run("Stream", func(b *testing.B) {
+		benchmarkParsePeakMemory(b, func(r io.Reader) error {
+			_, _, err := dom.ParseStream(r, dom.ParseOptions{})
+			return err
+		})
+	})
+}
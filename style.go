@@ -0,0 +1,303 @@
+package dom
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// CSSDeclaration is a single declaration inside a style attribute,
+// e.g. `color: red !important`.
+type CSSDeclaration struct {
+	Property string
+	Value    string
+	Priority string
+}
+
+// String returns the serialization of the declaration.
+func (d CSSDeclaration) String() string {
+	if d.Priority != "" {
+		return d.Property + ": " + d.Value + " !" + d.Priority + ";"
+	}
+	return d.Property + ": " + d.Value + ";"
+}
+
+// CSSStyleDeclaration is a live view of the declarations inside the
+// style attribute of an element, just like the style property in browser.
+// The declarations are parsed each time they're accessed, and written back
+// into the style attribute when they're modified. The attribute is removed
+// once there are no declarations left.
+//
+// Property values are not validated, except that they must be a single
+// value without `;` or priority.
+type CSSStyleDeclaration struct {
+	node *html.Node
+}
+
+// Style returns the inline style declarations of node.
+func Style(node *html.Node) *CSSStyleDeclaration {
+	return &CSSStyleDeclaration{node: node}
+}
+
+// Declarations returns all declarations in their order.
+func (s *CSSStyleDeclaration) Declarations() []CSSDeclaration {
+	return ParseStyleDeclarations(GetAttribute(s.node, "style"))
+}
+
+// Len returns the number of declarations.
+func (s *CSSStyleDeclaration) Len() int {
+	return len(s.Declarations())
+}
+
+// Item returns the property name of the declaration at the specified
+// index, or empty string if the index is out of range.
+func (s *CSSStyleDeclaration) Item(index int) string {
+	declarations := s.Declarations()
+	if index < 0 || index >= len(declarations) {
+		return ""
+	}
+	return declarations[index].Property
+}
+
+// GetPropertyValue returns the value of the specified property,
+// or empty string if the property is not set.
+func (s *CSSStyleDeclaration) GetPropertyValue(property string) string {
+	d, _ := s.find(property)
+	return d.Value
+}
+
+// GetPropertyPriority returns the priority of the specified property,
+// i.e. "important" or empty string.
+func (s *CSSStyleDeclaration) GetPropertyPriority(property string) string {
+	d, _ := s.find(property)
+	return d.Priority
+}
+
+// SetProperty sets the value and priority of the specified property.
+// If the property already exists it's modified in place, otherwise it's
+// appended to the end. Empty value removes the property. Invalid value
+// or priority (anything other than "important") is ignored.
+func (s *CSSStyleDeclaration) SetProperty(property, value string, priority ...string) {
+	property = normalizeCSSProperty(property)
+	if property == "" {
+		return
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		s.RemoveProperty(property)
+		return
+	}
+
+	// Make sure the value can't smuggle another declaration
+	parsed := ParseStyleDeclarations("x:" + value)
+	if len(parsed) != 1 || parsed[0].Value != value || parsed[0].Priority != "" {
+		return
+	}
+
+	var prio string
+	if len(priority) > 0 {
+		prio = strings.ToLower(priority[0])
+		if prio != "" && prio != "important" {
+			return
+		}
+	}
+
+	declarations := s.Declarations()
+	newDecl := CSSDeclaration{Property: property, Value: value, Priority: prio}
+	if _, i := s.find(property); i >= 0 {
+		declarations[i] = newDecl
+	} else {
+		declarations = append(declarations, newDecl)
+	}
+	s.update(declarations)
+}
+
+// RemoveProperty removes the specified property and returns its
+// old value.
+func (s *CSSStyleDeclaration) RemoveProperty(property string) string {
+	d, i := s.find(property)
+	if i < 0 {
+		return ""
+	}
+
+	declarations := s.Declarations()
+	declarations = append(declarations[:i], declarations[i+1:]...)
+	s.update(declarations)
+	return d.Value
+}
+
+// CSSText returns the serialization of all declarations.
+func (s *CSSStyleDeclaration) CSSText() string {
+	return serializeStyleDeclarations(s.Declarations())
+}
+
+// SetCSSText replaces all declarations by parsing the specified text.
+func (s *CSSStyleDeclaration) SetCSSText(text string) {
+	s.update(ParseStyleDeclarations(text))
+}
+
+// String returns the same value as CSSText().
+func (s *CSSStyleDeclaration) String() string {
+	return s.CSSText()
+}
+
+func (s *CSSStyleDeclaration) find(property string) (CSSDeclaration, int) {
+	property = normalizeCSSProperty(property)
+	for i, d := range s.Declarations() {
+		if d.Property == property {
+			return d, i
+		}
+	}
+	return CSSDeclaration{}, -1
+}
+
+func (s *CSSStyleDeclaration) update(declarations []CSSDeclaration) {
+	if len(declarations) == 0 {
+		RemoveAttribute(s.node, "style")
+		return
+	}
+	SetAttribute(s.node, "style", serializeStyleDeclarations(declarations))
+}
+
+// ParseStyleDeclarations parses a list of CSS declarations, e.g. the
+// content of style attribute. Comments and invalid declarations are
+// dropped. If the same property is declared several times, the last one
+// wins unless the earlier one is important.
+func ParseStyleDeclarations(text string) []CSSDeclaration {
+	var declarations []CSSDeclaration
+	for _, raw := range splitCSSDeclarations(text) {
+		colon := strings.IndexByte(raw, ':')
+		if colon < 0 {
+			continue
+		}
+
+		property := normalizeCSSProperty(raw[:colon])
+		value := strings.TrimSpace(raw[colon+1:])
+		if property == "" || !isCSSIdent(property) {
+			continue
+		}
+
+		var priority string
+		if i := strings.LastIndexByte(value, '!'); i >= 0 {
+			if strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+				priority = "important"
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		if value == "" {
+			continue
+		}
+
+		d := CSSDeclaration{Property: property, Value: value, Priority: priority}
+		replaced := false
+		for i, old := range declarations {
+			if old.Property != property {
+				continue
+			}
+
+			if old.Priority == "" || d.Priority != "" {
+				declarations = append(declarations[:i], declarations[i+1:]...)
+			} else {
+				replaced = true
+			}
+			break
+		}
+
+		if !replaced {
+			declarations = append(declarations, d)
+		}
+	}
+
+	return declarations
+}
+
+// splitCSSDeclarations splits text by semicolons outside of strings,
+// brackets and comments. Comments are replaced by single space.
+func splitCSSDeclarations(text string) []string {
+	var (
+		parts []string
+		sb    strings.Builder
+		quote byte
+		depth int
+	)
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text):
+			sb.WriteString(text[i : i+2])
+			i++
+
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			sb.WriteByte(c)
+
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				i = len(text)
+			} else {
+				i += end + 3
+			}
+			sb.WriteByte(' ')
+
+		case c == '"' || c == '\'':
+			quote = c
+			sb.WriteByte(c)
+
+		case c == '(' || c == '[' || c == '{':
+			depth++
+			sb.WriteByte(c)
+
+		case c == ')' || c == ']' || c == '}':
+			if depth > 0 {
+				depth--
+			}
+			sb.WriteByte(c)
+
+		case c == ';' && depth == 0:
+			parts = append(parts, sb.String())
+			sb.Reset()
+
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	parts = append(parts, sb.String())
+	return parts
+}
+
+func serializeStyleDeclarations(declarations []CSSDeclaration) string {
+	parts := make([]string, len(declarations))
+	for i, d := range declarations {
+		parts[i] = d.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+// normalizeCSSProperty lowercases property name, except custom
+// properties (--name) which are case-sensitive.
+func normalizeCSSProperty(property string) string {
+	property = strings.TrimSpace(property)
+	if strings.HasPrefix(property, "--") {
+		return property
+	}
+	return strings.ToLower(property)
+}
+
+// isCSSIdent reports whether s looks like a property name.
+func isCSSIdent(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c == '-' || c == '_' || c >= 0x80 || isDigit(c) ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package dom_test
+
+import (
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestParseStyleDeclarations(t *testing.T) {
+	tests := map[string]string{
+		"":                                            "",
+		"color:red":                                   "color: red;",
+		" COLOR : Red ; ; width:1px;":                 "color: Red; width: 1px;",
+		"color: red !important; width: 1px":           "color: red !important; width: 1px;",
+		"color: red ! IMPORTANT":                      "color: red !important;",
+		"color: red; color: blue":                     "color: blue;",
+		"color: red !important; color: blue":          "color: red !important;",
+		"color: red; width: 1px; color: blue":         "width: 1px; color: blue;",
+		"background: url('a;b.png'); top: 0":          "background: url('a;b.png'); top: 0;",
+		`content: "a;b" ; top: 0`:                     `content: "a;b"; top: 0;`,
+		"display: /* none; */ block":                  "display: block;",
+		"--Main-Color: red; COLOR: var(--Main-Color)": "--Main-Color: red; color: var(--Main-Color);",
+		"no-colon; : empty; width: ; a b: c":          "",
+	}
+
+	for text, want := range tests {
+		t.Run(text, func(t *testing.T) {
+			node := dom.CreateElement("div")
+			dom.SetAttribute(node, "style", text)
+
+			if got := dom.Style(node).CSSText(); got != want {
+				t.Errorf("CSSText() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStyle(t *testing.T) {
+	node := dom.CreateElement("div")
+	dom.SetAttribute(node, "style", "color: red; margin: 0 !important")
+	style := dom.Style(node)
+
+	if got := style.Len(); got != 2 {
+		t.Errorf("Len() = %v, want %v", got, 2)
+	}
+
+	if got := style.Item(1); got != "margin" {
+		t.Errorf("Item() = %q, want %q", got, "margin")
+	}
+
+	if got := style.GetPropertyValue("COLOR"); got != "red" {
+		t.Errorf("GetPropertyValue() = %q, want %q", got, "red")
+	}
+
+	if got := style.GetPropertyPriority("margin"); got != "important" {
+		t.Errorf("GetPropertyPriority() = %q, want %q", got, "important")
+	}
+
+	// Existing property is modified in place
+	style.SetProperty("color", "blue", "important")
+	style.SetProperty("width", "10px")
+	if got, want := dom.GetAttribute(node, "style"), "color: blue !important; margin: 0 !important; width: 10px;"; got != want {
+		t.Errorf("SetProperty() = %q, want %q", got, want)
+	}
+
+	// Invalid values are ignored
+	style.SetProperty("width", "1px; display: none")
+	style.SetProperty("width", "1px !important")
+	style.SetProperty("width", "1px", "high")
+	if got := style.GetPropertyValue("width"); got != "10px" {
+		t.Errorf("SetProperty() with invalid value = %q, want %q", got, "10px")
+	}
+
+	if got := style.RemoveProperty("color"); got != "blue" {
+		t.Errorf("RemoveProperty() = %q, want %q", got, "blue")
+	}
+
+	style.SetProperty("margin", "")
+	style.RemoveProperty("width")
+	if dom.HasAttribute(node, "style") {
+		t.Errorf("style attribute still exists: %q", dom.GetAttribute(node, "style"))
+	}
+
+	style.SetCSSText("top: 0; left: 0")
+	if got := style.String(); got != "top: 0; left: 0;" {
+		t.Errorf("SetCSSText() = %q, want %q", got, "top: 0; left: 0;")
+	}
+}
+
+func TestInnerTextHiddenStyle(t *testing.T) {
+	htmlSource := `<div>
+		<p>visible</p>
+		<p style="display:none">display none</p>
+		<p style="DISPLAY: None !important">display none important</p>
+		<p style="visibility: collapse">visibility collapse</p>
+		<p style="display: none-ish">not hidden</p>
+		<p style="/* display:none */ color: red">commented</p>
+		<p style="display: none; display: block">overridden</p>
+	</div>`
+
+	doc, err := parseHTMLSource(htmlSource)
+	if err != nil {
+		t.Errorf("InnerText(), failed to parse: %v", err)
+	}
+
+	want := "visible\n\nnot hidden\n\ncommented\n\noverridden"
+	if got := dom.InnerText(doc); got != want {
+		t.Errorf("InnerText() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package dom
+
+import (
+	"runtime"
+	"sync"
+	"weak"
+
+	"golang.org/x/net/html"
+)
+
+// nodeTable keeps a value for nodes outside of the tree, e.g. the URL of
+// a document, so it's not visible in the attributes and not copied by
+// Clone. The nodes are referred weakly and their entries are removed once
+// the nodes are garbage collected, so the values must not refer to them.
+type nodeTable[V any] struct {
+	mu      sync.RWMutex
+	entries map[weak.Pointer[html.Node]]V
+}
+
+func (t *nodeTable[V]) get(node *html.Node) (V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	value, exist := t.entries[weak.Make(node)]
+	return value, exist
+}
+
+func (t *nodeTable[V]) set(node *html.Node, value V) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[weak.Pointer[html.Node]]V)
+	}
+
+	key := weak.Make(node)
+	if _, exist := t.entries[key]; !exist {
+		runtime.AddCleanup(node, t.remove, key)
+	}
+	t.entries[key] = value
+}
+
+func (t *nodeTable[V]) delete(node *html.Node) {
+	t.remove(weak.Make(node))
+}
+
+func (t *nodeTable[V]) remove(key weak.Pointer[html.Node]) {
+	t.mu.Lock()
+	delete(t.entries, key)
+	t.mu.Unlock()
+}
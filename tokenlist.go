@@ -0,0 +1,202 @@
+package dom
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// TokenList is a live view of a set of space-separated tokens stored in
+// an attribute, e.g. class or rel, just like DOMTokenList in browser.
+// The tokens are kept in their original order without duplicates, and
+// the attribute is removed once there are no tokens left.
+//
+// Unlike DOMTokenList, invalid tokens (empty or containing whitespace)
+// are silently ignored instead of raising an error.
+type TokenList struct {
+	node     *html.Node
+	attrName string
+}
+
+// NewTokenList returns the TokenList for the specified attribute of node,
+// e.g. NewTokenList(iframe, "sandbox").
+func NewTokenList(node *html.Node, attrName string) *TokenList {
+	return &TokenList{node: node, attrName: attrName}
+}
+
+// ClassList returns the TokenList for the class attribute of node.
+func ClassList(node *html.Node) *TokenList {
+	return NewTokenList(node, "class")
+}
+
+// RelList returns the TokenList for the rel attribute of node.
+func RelList(node *html.Node) *TokenList {
+	return NewTokenList(node, "rel")
+}
+
+// Len returns the number of tokens in the list.
+func (l *TokenList) Len() int {
+	return len(l.Items())
+}
+
+// Item returns the token at the specified index, or empty string
+// if the index is out of range.
+func (l *TokenList) Item(index int) string {
+	items := l.Items()
+	if index < 0 || index >= len(items) {
+		return ""
+	}
+	return items[index]
+}
+
+// Items returns all tokens in the list.
+func (l *TokenList) Items() []string {
+	var items []string
+	for _, token := range strings.Fields(GetAttribute(l.node, l.attrName)) {
+		if !stringInSlice(token, items) {
+			items = append(items, token)
+		}
+	}
+	return items
+}
+
+// Contains reports whether token exists in the list.
+func (l *TokenList) Contains(token string) bool {
+	return stringInSlice(token, strings.Fields(GetAttribute(l.node, l.attrName)))
+}
+
+// Add adds the specified tokens to the end of the list, except
+// the ones which already exist.
+func (l *TokenList) Add(tokens ...string) {
+	items := l.Items()
+	for _, token := range tokens {
+		if isValidToken(token) && !stringInSlice(token, items) {
+			items = append(items, token)
+		}
+	}
+	l.update(items)
+}
+
+// Remove removes the specified tokens from the list.
+func (l *TokenList) Remove(tokens ...string) {
+	var items []string
+	for _, item := range l.Items() {
+		if !stringInSlice(item, tokens) {
+			items = append(items, item)
+		}
+	}
+	l.update(items)
+}
+
+// Toggle removes token if it exists, otherwise adds it. If force is
+// specified, the token is only added when force is true and only
+// removed when force is false. Returns true if token exists afterwards.
+func (l *TokenList) Toggle(token string, force ...bool) bool {
+	if !isValidToken(token) {
+		return false
+	}
+
+	if l.Contains(token) {
+		if len(force) > 0 && force[0] {
+			return true
+		}
+		l.Remove(token)
+		return false
+	}
+
+	if len(force) > 0 && !force[0] {
+		return false
+	}
+	l.Add(token)
+	return true
+}
+
+// Replace replaces oldToken with newToken in place. Returns false if
+// oldToken doesn't exist in the list.
+func (l *TokenList) Replace(oldToken, newToken string) bool {
+	if !isValidToken(oldToken) || !isValidToken(newToken) {
+		return false
+	}
+
+	items := l.Items()
+	if !stringInSlice(oldToken, items) {
+		return false
+	}
+
+	var result []string
+	for _, item := range items {
+		switch {
+		case item == oldToken && !stringInSlice(newToken, result):
+			result = append(result, newToken)
+		case item != oldToken && item != newToken:
+			result = append(result, item)
+		case item == newToken && !stringInSlice(newToken, result):
+			result = append(result, newToken)
+		}
+	}
+
+	l.update(result)
+	return true
+}
+
+// Value returns the normalized value of the list, i.e. the tokens
+// separated by single space.
+func (l *TokenList) Value() string {
+	return strings.Join(l.Items(), " ")
+}
+
+// SetValue replaces the attribute value with the specified value.
+func (l *TokenList) SetValue(value string) {
+	SetAttribute(l.node, l.attrName, value)
+}
+
+// String returns the same value as Value().
+func (l *TokenList) String() string {
+	return l.Value()
+}
+
+// update writes the tokens back into the attribute.
+func (l *TokenList) update(items []string) {
+	if len(items) == 0 {
+		RemoveAttribute(l.node, l.attrName)
+		return
+	}
+
+	value := strings.Join(items, " ")
+	if GetAttribute(l.node, l.attrName) != value {
+		SetAttribute(l.node, l.attrName, value)
+	}
+}
+
+// AddClass adds the specified classes to node.
+func AddClass(node *html.Node, classNames ...string) {
+	ClassList(node).Add(classNames...)
+}
+
+// RemoveClass removes the specified classes from node.
+func RemoveClass(node *html.Node, classNames ...string) {
+	ClassList(node).Remove(classNames...)
+}
+
+// ToggleClass toggles the specified class of node, following the same
+// rules as TokenList.Toggle(). Returns true if node has the class afterwards.
+func ToggleClass(node *html.Node, className string, force ...bool) bool {
+	return ClassList(node).Toggle(className, force...)
+}
+
+// HasClass reports whether node has the specified class.
+func HasClass(node *html.Node, className string) bool {
+	return ClassList(node).Contains(className)
+}
+
+// ReplaceClass replaces oldClass of node with newClass. Returns false
+// if node doesn't have oldClass.
+func ReplaceClass(node *html.Node, oldClass, newClass string) bool {
+	return ClassList(node).Replace(oldClass, newClass)
+}
+
+// isValidToken reports whether token is not empty and
+// doesn't contain any whitespace.
+func isValidToken(token string) bool {
+	return token != "" && !strings.ContainsAny(token, " \t\n\f\r")
+}
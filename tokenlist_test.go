@@ -0,0 +1,173 @@
+package dom_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+)
+
+func TestTokenList(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  string
+		fn       func(*dom.TokenList) interface{}
+		want     string
+		wantAttr bool
+		result   interface{}
+	}{{
+		name:     "items are deduplicated",
+		initial:  " b  a b\tc ",
+		fn:       func(l *dom.TokenList) interface{} { return strings.Join(l.Items(), ",") },
+		want:     " b  a b\tc ",
+		wantAttr: true,
+		result:   "b,a,c",
+	}, {
+		name:     "item",
+		initial:  "a b",
+		fn:       func(l *dom.TokenList) interface{} { return l.Item(1) + l.Item(2) + l.Item(-1) },
+		want:     "a b",
+		wantAttr: true,
+		result:   "b",
+	}, {
+		name:     "add",
+		initial:  "a  b",
+		fn:       func(l *dom.TokenList) interface{} { l.Add("c", "a", "d", "c"); return nil },
+		want:     "a b c d",
+		wantAttr: true,
+	}, {
+		name:     "add invalid",
+		initial:  "a",
+		fn:       func(l *dom.TokenList) interface{} { l.Add("", "b c"); return nil },
+		want:     "a",
+		wantAttr: true,
+	}, {
+		name:     "add to missing attribute",
+		fn:       func(l *dom.TokenList) interface{} { l.Add("a"); return nil },
+		want:     "a",
+		wantAttr: true,
+	}, {
+		name:     "remove",
+		initial:  "a b c b",
+		fn:       func(l *dom.TokenList) interface{} { l.Remove("b", "x"); return nil },
+		want:     "a c",
+		wantAttr: true,
+	}, {
+		name:    "remove last",
+		initial: "a a",
+		fn:      func(l *dom.TokenList) interface{} { l.Remove("a"); return nil },
+	}, {
+		name:     "toggle off",
+		initial:  "a b",
+		fn:       func(l *dom.TokenList) interface{} { return l.Toggle("a") },
+		want:     "b",
+		wantAttr: true,
+		result:   false,
+	}, {
+		name:     "toggle on",
+		initial:  "a",
+		fn:       func(l *dom.TokenList) interface{} { return l.Toggle("b") },
+		want:     "a b",
+		wantAttr: true,
+		result:   true,
+	}, {
+		name:     "toggle force true",
+		initial:  "a",
+		fn:       func(l *dom.TokenList) interface{} { return l.Toggle("a", true) },
+		want:     "a",
+		wantAttr: true,
+		result:   true,
+	}, {
+		name:     "toggle force false",
+		initial:  "a",
+		fn:       func(l *dom.TokenList) interface{} { return l.Toggle("b", false) },
+		want:     "a",
+		wantAttr: true,
+		result:   false,
+	}, {
+		name:     "replace",
+		initial:  "a b c",
+		fn:       func(l *dom.TokenList) interface{} { return l.Replace("b", "d") },
+		want:     "a d c",
+		wantAttr: true,
+		result:   true,
+	}, {
+		name:     "replace with existing",
+		initial:  "a b c",
+		fn:       func(l *dom.TokenList) interface{} { return l.Replace("c", "a") },
+		want:     "a b",
+		wantAttr: true,
+		result:   true,
+	}, {
+		name:     "replace missing",
+		initial:  "a b",
+		fn:       func(l *dom.TokenList) interface{} { return l.Replace("x", "y") },
+		want:     "a b",
+		wantAttr: true,
+		result:   false,
+	}, {
+		name:     "contains",
+		initial:  "a b",
+		fn:       func(l *dom.TokenList) interface{} { return l.Contains("b") && !l.Contains("a b") },
+		want:     "a b",
+		wantAttr: true,
+		result:   true,
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := dom.CreateElement("a")
+			if tt.initial != "" {
+				dom.SetAttribute(node, "rel", tt.initial)
+			}
+
+			if result := tt.fn(dom.RelList(node)); result != tt.result {
+				t.Errorf("TokenList result = %v, want %v", result, tt.result)
+			}
+
+			if got := dom.HasAttribute(node, "rel"); got != tt.wantAttr {
+				t.Errorf("HasAttribute() = %v, want %v", got, tt.wantAttr)
+			}
+
+			if got := dom.GetAttribute(node, "rel"); got != tt.want {
+				t.Errorf("GetAttribute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassHelpers(t *testing.T) {
+	node := dom.CreateElement("div")
+
+	dom.AddClass(node, "a", "b", "a")
+	if got := dom.ClassName(node); got != "a b" {
+		t.Errorf("AddClass() = %q, want %q", got, "a b")
+	}
+
+	if !dom.HasClass(node, "b") || dom.HasClass(node, "c") {
+		t.Errorf("HasClass() = %v, want %v", dom.HasClass(node, "b"), true)
+	}
+
+	if !dom.ReplaceClass(node, "a", "c") || dom.ClassName(node) != "c b" {
+		t.Errorf("ReplaceClass() = %q, want %q", dom.ClassName(node), "c b")
+	}
+
+	if dom.ToggleClass(node, "b") || dom.ClassName(node) != "c" {
+		t.Errorf("ToggleClass() = %q, want %q", dom.ClassName(node), "c")
+	}
+
+	dom.RemoveClass(node, "c")
+	if dom.HasAttribute(node, "class") {
+		t.Errorf("RemoveClass() keeps empty class attribute")
+	}
+
+	sandbox := dom.NewTokenList(dom.CreateElement("iframe"), "sandbox")
+	sandbox.Add("allow-scripts", "allow-forms")
+	if got := sandbox.Value(); got != "allow-scripts allow-forms" {
+		t.Errorf("TokenList.Value() = %q, want %q", got, "allow-scripts allow-forms")
+	}
+
+	if got := sandbox.Len(); got != 2 {
+		t.Errorf("TokenList.Len() = %v, want %v", got, 2)
+	}
+}
@@ -0,0 +1,513 @@
+package dom
+
+import (
+	"runtime"
+
+	"golang.org/x/net/html"
+)
+
+// WhatToShow is a bitmask of node types which are visible to
+// TreeWalker and NodeIterator.
+type WhatToShow uint32
+
+// Node types used in WhatToShow. The values are the same as NodeFilter
+// constants in JS.
+const (
+	ShowElement      WhatToShow = 0x1
+	ShowText         WhatToShow = 0x4
+	ShowComment      WhatToShow = 0x80
+	ShowDocument     WhatToShow = 0x100
+	ShowDocumentType WhatToShow = 0x200
+	ShowAll          WhatToShow = 0xFFFFFFFF
+)
+
+// FilterResult is the value returned by NodeFilter.
+type FilterResult int
+
+// Values for FilterResult.
+const (
+	// FilterAccept marks the node as visible.
+	FilterAccept FilterResult = 1 + iota
+
+	// FilterReject marks the node and its descendants as invisible.
+	// NodeIterator treats it the same as FilterSkip.
+	FilterReject
+
+	// FilterSkip marks the node as invisible, but its descendants
+	// might still be visible.
+	FilterSkip
+)
+
+// NodeFilter decides whether a node is visible to TreeWalker and
+// NodeIterator. It's only called for nodes allowed by WhatToShow.
+type NodeFilter func(*html.Node) FilterResult
+
+// filterNode returns the result of filtering node by whatToShow and filter.
+func filterNode(node *html.Node, whatToShow WhatToShow, filter NodeFilter) FilterResult {
+	var mask WhatToShow
+	switch node.Type {
+	case html.ElementNode:
+		mask = ShowElement
+	case html.TextNode, html.RawNode:
+		mask = ShowText
+	case html.CommentNode:
+		mask = ShowComment
+	case html.DocumentNode:
+		mask = ShowDocument
+	case html.DoctypeNode:
+		mask = ShowDocumentType
+	}
+
+	if whatToShow&mask == 0 {
+		return FilterSkip
+	}
+
+	if filter == nil {
+		return FilterAccept
+	}
+
+	return filter(node)
+}
+
+// TreeWalker represents the nodes of a subtree and a position within
+// them. Since it only keeps track of its current node, it stays valid
+// when the tree is mutated.
+type TreeWalker struct {
+	root        *html.Node
+	whatToShow  WhatToShow
+	filter      NodeFilter
+	currentNode *html.Node
+}
+
+// NewTreeWalker creates a TreeWalker for the subtree of root. Only nodes
+// matching whatToShow and accepted by filter are visible. Filter can be
+// nil to accept all nodes matching whatToShow.
+func NewTreeWalker(root *html.Node, whatToShow WhatToShow, filter NodeFilter) *TreeWalker {
+	return &TreeWalker{
+		root:        root,
+		whatToShow:  whatToShow,
+		filter:      filter,
+		currentNode: root,
+	}
+}
+
+// Root returns the root node of the walker.
+func (w *TreeWalker) Root() *html.Node {
+	return w.root
+}
+
+// WhatToShow returns the node types visible to the walker.
+func (w *TreeWalker) WhatToShow() WhatToShow {
+	return w.whatToShow
+}
+
+// CurrentNode returns the node where the walker is positioned.
+func (w *TreeWalker) CurrentNode() *html.Node {
+	return w.currentNode
+}
+
+// SetCurrentNode moves the walker to the specified node.
+func (w *TreeWalker) SetCurrentNode(node *html.Node) {
+	if node != nil {
+		w.currentNode = node
+	}
+}
+
+func (w *TreeWalker) accept(node *html.Node) FilterResult {
+	return filterNode(node, w.whatToShow, w.filter)
+}
+
+// ParentNode moves to and returns the closest visible ancestor of the
+// current node, or nil if there is none inside root.
+func (w *TreeWalker) ParentNode() *html.Node {
+	node := w.currentNode
+	for node != nil && node != w.root {
+		node = node.Parent
+		if node != nil && w.accept(node) == FilterAccept {
+			w.currentNode = node
+			return node
+		}
+	}
+	return nil
+}
+
+// FirstChild moves to and returns the first visible child of the
+// current node, or nil if there is none.
+func (w *TreeWalker) FirstChild() *html.Node {
+	return w.traverseChildren(true)
+}
+
+// LastChild moves to and returns the last visible child of the
+// current node, or nil if there is none.
+func (w *TreeWalker) LastChild() *html.Node {
+	return w.traverseChildren(false)
+}
+
+// NextSibling moves to and returns the next visible sibling of the
+// current node, or nil if there is none.
+func (w *TreeWalker) NextSibling() *html.Node {
+	return w.traverseSiblings(true)
+}
+
+// PreviousSibling moves to and returns the previous visible sibling of
+// the current node, or nil if there is none.
+func (w *TreeWalker) PreviousSibling() *html.Node {
+	return w.traverseSiblings(false)
+}
+
+// NextNode moves to and returns the next visible node in document
+// order, or nil if there is none inside root.
+func (w *TreeWalker) NextNode() *html.Node {
+	node := w.currentNode
+	result := FilterAccept
+
+	for {
+		for result != FilterReject && node.FirstChild != nil {
+			node = node.FirstChild
+			result = w.accept(node)
+			if result == FilterAccept {
+				w.currentNode = node
+				return node
+			}
+		}
+
+		var sibling *html.Node
+		for temporary := node; sibling == nil; temporary = temporary.Parent {
+			if temporary == nil || temporary == w.root {
+				return nil
+			}
+			sibling = temporary.NextSibling
+		}
+
+		node = sibling
+		result = w.accept(node)
+		if result == FilterAccept {
+			w.currentNode = node
+			return node
+		}
+	}
+}
+
+// PreviousNode moves to and returns the previous visible node in
+// document order, or nil if there is none inside root.
+func (w *TreeWalker) PreviousNode() *html.Node {
+	node := w.currentNode
+	for node != w.root {
+		for sibling := node.PrevSibling; sibling != nil; sibling = node.PrevSibling {
+			node = sibling
+			result := w.accept(node)
+			for result != FilterReject && node.LastChild != nil {
+				node = node.LastChild
+				result = w.accept(node)
+			}
+
+			if result == FilterAccept {
+				w.currentNode = node
+				return node
+			}
+		}
+
+		if node == w.root || node.Parent == nil {
+			return nil
+		}
+
+		node = node.Parent
+		if w.accept(node) == FilterAccept {
+			w.currentNode = node
+			return node
+		}
+	}
+	return nil
+}
+
+func (w *TreeWalker) traverseChildren(first bool) *html.Node {
+	node := w.currentNode.LastChild
+	if first {
+		node = w.currentNode.FirstChild
+	}
+
+	for node != nil {
+		switch w.accept(node) {
+		case FilterAccept:
+			w.currentNode = node
+			return node
+
+		case FilterSkip:
+			child := node.LastChild
+			if first {
+				child = node.FirstChild
+			}
+
+			if child != nil {
+				node = child
+				continue
+			}
+		}
+
+		for node != nil {
+			sibling := node.PrevSibling
+			if first {
+				sibling = node.NextSibling
+			}
+
+			if sibling != nil {
+				node = sibling
+				break
+			}
+
+			parent := node.Parent
+			if parent == nil || parent == w.root || parent == w.currentNode {
+				return nil
+			}
+			node = parent
+		}
+	}
+	return nil
+}
+
+func (w *TreeWalker) traverseSiblings(next bool) *html.Node {
+	node := w.currentNode
+	if node == w.root {
+		return nil
+	}
+
+	siblingOf := func(n *html.Node) *html.Node {
+		if next {
+			return n.NextSibling
+		}
+		return n.PrevSibling
+	}
+
+	for {
+		sibling := siblingOf(node)
+		for sibling != nil {
+			node = sibling
+			result := w.accept(node)
+			if result == FilterAccept {
+				w.currentNode = node
+				return node
+			}
+
+			sibling = node.LastChild
+			if next {
+				sibling = node.FirstChild
+			}
+
+			if result == FilterReject || sibling == nil {
+				sibling = siblingOf(node)
+			}
+		}
+
+		node = node.Parent
+		if node == nil || node == w.root {
+			return nil
+		}
+
+		if w.accept(node) == FilterAccept {
+			return nil
+		}
+	}
+}
+
+// NodeIterator iterates over the visible nodes of a subtree in document
+// order. It stays valid when nodes are removed or moved using functions
+// in this package, e.g. RemoveNodes() or AppendChild(). Mutations done
+// directly through html.Node methods can't be tracked.
+//
+// Only removals inside the subtree of the iterator have to update it, so
+// live iterators don't slow down modifications of other trees. Like in
+// the DOM standard, calling Detach() is optional: an iterator that's no
+// longer referenced stops tracking mutations once it's garbage collected.
+type NodeIterator struct {
+	whatToShow WhatToShow
+	filter     NodeFilter
+	pointer    *iteratorPointer
+}
+
+// iteratorPointer is the position of a NodeIterator, which is updated
+// when nodes are removed. It's kept apart from the NodeIterator, so the
+// registered pointer doesn't keep the iterator reachable.
+type iteratorPointer struct {
+	root                       *html.Node
+	referenceNode              *html.Node
+	pointerBeforeReferenceNode bool
+}
+
+// NewNodeIterator creates a NodeIterator for the subtree of root. Only
+// nodes matching whatToShow and accepted by filter are visible. Filter
+// can be nil to accept all nodes matching whatToShow.
+func NewNodeIterator(root *html.Node, whatToShow WhatToShow, filter NodeFilter) *NodeIterator {
+	it := &NodeIterator{
+		whatToShow: whatToShow,
+		filter:     filter,
+		pointer: &iteratorPointer{
+			root:                       root,
+			referenceNode:              root,
+			pointerBeforeReferenceNode: true,
+		},
+	}
+
+	liveIterators.add(root, it.pointer)
+	runtime.SetFinalizer(it, (*NodeIterator).Detach)
+	return it
+}
+
+// Root returns the root node of the iterator.
+func (it *NodeIterator) Root() *html.Node {
+	return it.pointer.root
+}
+
+// WhatToShow returns the node types visible to the iterator.
+func (it *NodeIterator) WhatToShow() WhatToShow {
+	return it.whatToShow
+}
+
+// ReferenceNode returns the node to which the iterator is anchored.
+func (it *NodeIterator) ReferenceNode() *html.Node {
+	return it.pointer.referenceNode
+}
+
+// PointerBeforeReferenceNode returns true if the iterator is anchored
+// before the reference node, or false if it's anchored after it.
+func (it *NodeIterator) PointerBeforeReferenceNode() bool {
+	return it.pointer.pointerBeforeReferenceNode
+}
+
+// NextNode returns the next visible node in document order and moves
+// the iterator after it, or returns nil if there are no more nodes.
+func (it *NodeIterator) NextNode() *html.Node {
+	return it.traverse(true)
+}
+
+// PreviousNode returns the previous visible node in document order and
+// moves the iterator before it, or returns nil if there are no more nodes.
+func (it *NodeIterator) PreviousNode() *html.Node {
+	return it.traverse(false)
+}
+
+// Detach stops the iterator from tracking mutations in the tree, which
+// otherwise happens when the iterator is garbage collected.
+func (it *NodeIterator) Detach() {
+	liveIterators.remove(it.pointer.root, it.pointer)
+}
+
+func (it *NodeIterator) traverse(next bool) *html.Node {
+	root := it.pointer.root
+	node := it.pointer.referenceNode
+	beforeNode := it.pointer.pointerBeforeReferenceNode
+
+	for {
+		if next {
+			if !beforeNode {
+				if node = followingNode(node, root); node == nil {
+					return nil
+				}
+			}
+			beforeNode = false
+		} else {
+			if beforeNode {
+				if node = precedingNode(node, root); node == nil {
+					return nil
+				}
+			}
+			beforeNode = true
+		}
+
+		if filterNode(node, it.whatToShow, it.filter) == FilterAccept {
+			break
+		}
+	}
+
+	it.pointer.referenceNode = node
+	it.pointer.pointerBeforeReferenceNode = beforeNode
+	return node
+}
+
+// nodeWillBeRemoved moves the reference node out of the subtree that is
+// going to be removed, following the removing steps in DOM standard.
+func (it *iteratorPointer) nodeWillBeRemoved(toBeRemoved *html.Node) {
+	if isInclusiveAncestor(toBeRemoved, it.root) || !isInclusiveAncestor(toBeRemoved, it.referenceNode) {
+		return
+	}
+
+	if it.pointerBeforeReferenceNode {
+		next := toBeRemoved
+		for next != nil && next != it.root && next.NextSibling == nil {
+			next = next.Parent
+		}
+
+		if next != nil && next != it.root && isInclusiveAncestor(it.root, next) {
+			it.referenceNode = next.NextSibling
+			return
+		}
+
+		it.pointerBeforeReferenceNode = false
+	}
+
+	if prev := toBeRemoved.PrevSibling; prev != nil {
+		for prev.LastChild != nil {
+			prev = prev.LastChild
+		}
+		it.referenceNode = prev
+	} else {
+		it.referenceNode = toBeRemoved.Parent
+	}
+}
+
+// liveIterators contains the pointers of NodeIterators which haven't been
+// detached, so they can be notified when a node is removed.
+var liveIterators liveRegistry[*iteratorPointer]
+
+// beforeNodeRemoved must be called before a node is removed from its
+// parent, so the live NodeIterators can update themselves. Only iterators
+// whose root is an ancestor of node are affected.
+func beforeNodeRemoved(node *html.Node) {
+	if node == nil || node.Parent == nil {
+		return
+	}
+
+	liveIterators.each(node.Parent, func(it *iteratorPointer) {
+		it.nodeWillBeRemoved(node)
+	})
+}
+
+// followingNode returns the node after node in document order,
+// or nil if there is none inside root.
+func followingNode(node, root *html.Node) *html.Node {
+	if node.FirstChild != nil {
+		return node.FirstChild
+	}
+
+	for ; node != nil && node != root; node = node.Parent {
+		if node.NextSibling != nil {
+			return node.NextSibling
+		}
+	}
+	return nil
+}
+
+// precedingNode returns the node before node in document order,
+// or nil if there is none inside root.
+func precedingNode(node, root *html.Node) *html.Node {
+	if node == root {
+		return nil
+	}
+
+	if prev := node.PrevSibling; prev != nil {
+		for prev.LastChild != nil {
+			prev = prev.LastChild
+		}
+		return prev
+	}
+	return node.Parent
+}
+
+// isInclusiveAncestor reports whether ancestor is node or one of its ancestors.
+func isInclusiveAncestor(ancestor, node *html.Node) bool {
+	for ; node != nil; node = node.Parent {
+		if node == ancestor {
+			return true
+		}
+	}
+	return false
+}
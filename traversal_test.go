@@ -0,0 +1,321 @@
+package dom_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+const traversalTestHTML = `<div id="root"><h1 id="title">Title</h1><!--note--><div id="body"><p id="p1">One</p><p id="p2" class="skip">Two <b id="b">bold</b></p><p id="p3" class="reject">Three <i id="i">italic</i></p></div><p id="p4">Four</p></div>`
+
+// nodeName returns id of element, text of text node and
+// the content of comment, to make test result easy to read.
+func nodeName(node *html.Node) string {
+	switch {
+	case node == nil:
+		return "<nil>"
+	case node.Type == html.ElementNode:
+		return dom.ID(node)
+	case node.Type == html.CommentNode:
+		return "!" + node.Data
+	default:
+		return strings.TrimSpace(node.Data)
+	}
+}
+
+func classFilter(node *html.Node) dom.FilterResult {
+	switch dom.ClassName(node) {
+	case "skip":
+		return dom.FilterSkip
+	case "reject":
+		return dom.FilterReject
+	default:
+		return dom.FilterAccept
+	}
+}
+
+func TestTreeWalkerNextNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		whatToShow dom.WhatToShow
+		filter     dom.NodeFilter
+		want       string
+	}{{
+		name:       "elements",
+		whatToShow: dom.ShowElement,
+		want:       "title,body,p1,p2,b,p3,i,p4",
+	}, {
+		name:       "text",
+		whatToShow: dom.ShowText,
+		want:       "Title,One,Two,bold,Three,italic,Four",
+	}, {
+		name:       "elements and comments",
+		whatToShow: dom.ShowElement | dom.ShowComment,
+		want:       "title,!note,body,p1,p2,b,p3,i,p4",
+	}, {
+		name:       "elements with filter",
+		whatToShow: dom.ShowElement,
+		filter:     classFilter,
+		want:       "title,body,p1,b,p4",
+	}, {
+		name:       "all with filter",
+		whatToShow: dom.ShowAll,
+		filter:     classFilter,
+		want:       "title,Title,!note,body,p1,One,Two,b,bold,p4,Four",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseHTMLSource(traversalTestHTML)
+			if err != nil {
+				t.Errorf("TreeWalker, failed to parse: %v", err)
+			}
+
+			root := dom.GetElementByID(doc, "root")
+			walker := dom.NewTreeWalker(root, tt.whatToShow, tt.filter)
+
+			var forward []string
+			for node := walker.NextNode(); node != nil; node = walker.NextNode() {
+				forward = append(forward, nodeName(node))
+			}
+
+			if got := strings.Join(forward, ","); got != tt.want {
+				t.Errorf("TreeWalker.NextNode() = %v, want %v", got, tt.want)
+			}
+
+			// Walking backward must give the same nodes in reverse,
+			// except the last one which is the current node.
+			var backward []string
+			for node := walker.PreviousNode(); node != nil && node != root; node = walker.PreviousNode() {
+				backward = append([]string{nodeName(node)}, backward...)
+			}
+
+			want := strings.Join(forward[:len(forward)-1], ",")
+			if got := strings.Join(backward, ","); got != want {
+				t.Errorf("TreeWalker.PreviousNode() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestTreeWalkerNavigation(t *testing.T) {
+	doc, err := parseHTMLSource(traversalTestHTML)
+	if err != nil {
+		t.Errorf("TreeWalker, failed to parse: %v", err)
+	}
+
+	root := dom.GetElementByID(doc, "root")
+	walker := dom.NewTreeWalker(root, dom.ShowElement, classFilter)
+
+	steps := []struct {
+		name string
+		move func() *html.Node
+		want string
+	}{
+		{"FirstChild", walker.FirstChild, "title"},
+		{"FirstChild", walker.FirstChild, "<nil>"},
+		{"NextSibling", walker.NextSibling, "body"},
+		{"LastChild", walker.LastChild, "b"},
+		{"PreviousSibling", walker.PreviousSibling, "p1"},
+		{"NextSibling", walker.NextSibling, "b"},
+		{"NextSibling", walker.NextSibling, "<nil>"},
+		{"ParentNode", walker.ParentNode, "body"},
+		{"NextSibling", walker.NextSibling, "p4"},
+		{"PreviousSibling", walker.PreviousSibling, "body"},
+		{"ParentNode", walker.ParentNode, "root"},
+		{"ParentNode", walker.ParentNode, "<nil>"},
+		{"LastChild", walker.LastChild, "p4"},
+		{"PreviousNode", walker.PreviousNode, "b"},
+	}
+
+	for i, step := range steps {
+		if got := nodeName(step.move()); got != step.want {
+			t.Fatalf("step %d: TreeWalker.%s() = %v, want %v", i, step.name, got, step.want)
+		}
+	}
+
+	if got := nodeName(walker.CurrentNode()); got != "b" {
+		t.Errorf("TreeWalker.CurrentNode() = %v, want b", got)
+	}
+
+	// Rejected node could still be the current node, in which case
+	// its children are still visited.
+	walker.SetCurrentNode(dom.GetElementByID(doc, "p3"))
+	if got := nodeName(walker.NextNode()); got != "i" {
+		t.Errorf("TreeWalker.NextNode() after SetCurrentNode() = %v, want i", got)
+	}
+}
+
+func TestTreeWalkerMutation(t *testing.T) {
+	doc, err := parseHTMLSource(traversalTestHTML)
+	if err != nil {
+		t.Errorf("TreeWalker, failed to parse: %v", err)
+	}
+
+	root := dom.GetElementByID(doc, "root")
+	walker := dom.NewTreeWalker(root, dom.ShowElement, nil)
+
+	var visited []string
+	for node := walker.NextNode(); node != nil; node = walker.NextNode() {
+		visited = append(visited, nodeName(node))
+
+		// Remove the next paragraph and append a new one while walking
+		if dom.ID(node) == "p1" {
+			dom.RemoveNodes([]*html.Node{dom.GetElementByID(doc, "p2")}, nil)
+			p := dom.CreateElement("p")
+			dom.SetAttribute(p, "id", "new")
+			dom.AppendChild(root, p)
+		}
+	}
+
+	want := "title,body,p1,p3,i,p4,new"
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("TreeWalker.NextNode() = %v, want %v", got, want)
+	}
+}
+
+func TestNodeIterator(t *testing.T) {
+	tests := []struct {
+		name       string
+		whatToShow dom.WhatToShow
+		filter     dom.NodeFilter
+		want       string
+	}{{
+		name:       "elements",
+		whatToShow: dom.ShowElement,
+		want:       "root,title,body,p1,p2,b,p3,i,p4",
+	}, {
+		name:       "comments",
+		whatToShow: dom.ShowComment,
+		want:       "!note",
+	}, {
+		name:       "elements with filter",
+		whatToShow: dom.ShowElement,
+		filter:     classFilter,
+		want:       "root,title,body,p1,b,i,p4",
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseHTMLSource(traversalTestHTML)
+			if err != nil {
+				t.Errorf("NodeIterator, failed to parse: %v", err)
+			}
+
+			it := dom.NewNodeIterator(dom.GetElementByID(doc, "root"), tt.whatToShow, tt.filter)
+			defer it.Detach()
+
+			var forward []string
+			for node := it.NextNode(); node != nil; node = it.NextNode() {
+				forward = append(forward, nodeName(node))
+			}
+
+			if got := strings.Join(forward, ","); got != tt.want {
+				t.Errorf("NodeIterator.NextNode() = %v, want %v", got, tt.want)
+			}
+
+			if it.PointerBeforeReferenceNode() {
+				t.Errorf("NodeIterator.PointerBeforeReferenceNode() = true, want false")
+			}
+
+			var backward []string
+			for node := it.PreviousNode(); node != nil; node = it.PreviousNode() {
+				backward = append([]string{nodeName(node)}, backward...)
+			}
+
+			if got := strings.Join(backward, ","); got != tt.want {
+				t.Errorf("NodeIterator.PreviousNode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeIteratorMutation(t *testing.T) {
+	doc, err := parseHTMLSource(traversalTestHTML)
+	if err != nil {
+		t.Errorf("NodeIterator, failed to parse: %v", err)
+	}
+
+	it := dom.NewNodeIterator(dom.GetElementByID(doc, "root"), dom.ShowElement, nil)
+	defer it.Detach()
+
+	var visited []string
+	for node := it.NextNode(); node != nil; node = it.NextNode() {
+		visited = append(visited, nodeName(node))
+
+		switch dom.ID(node) {
+		case "p2":
+			// Remove the reference node itself
+			dom.RemoveNodes([]*html.Node{node}, nil)
+
+		case "p3":
+			// Move the following paragraph before the reference node
+			p4 := dom.GetElementByID(doc, "p4")
+			dom.PrependChild(dom.GetElementByID(doc, "body"), p4)
+		}
+	}
+
+	want := "root,title,body,p1,p2,p3,i"
+	if got := strings.Join(visited, ","); got != want {
+		t.Errorf("NodeIterator.NextNode() = %v, want %v", got, want)
+	}
+
+	if got := nodeName(it.ReferenceNode()); got != "i" {
+		t.Errorf("NodeIterator.ReferenceNode() = %v, want i", got)
+	}
+
+	// Removing the reference node while pointer is before it
+	// moves the reference to the following node, whatever its type
+	it2 := dom.NewNodeIterator(dom.GetElementByID(doc, "root"), dom.ShowElement, nil)
+	defer it2.Detach()
+
+	it2.NextNode()
+	it2.NextNode()
+	if got := nodeName(it2.PreviousNode()); got != "title" {
+		t.Fatalf("NodeIterator.PreviousNode() = %v, want title", got)
+	}
+
+	dom.RemoveNodes([]*html.Node{dom.GetElementByID(doc, "title")}, nil)
+	if got := nodeName(it2.ReferenceNode()); got != "!note" {
+		t.Errorf("NodeIterator.ReferenceNode() = %v, want !note", got)
+	}
+
+	if got := nodeName(it2.NextNode()); got != "body" {
+		t.Errorf("NodeIterator.NextNode() = %v, want body", got)
+	}
+}
+
+func TestNodeIteratorWithoutDetach(t *testing.T) {
+	// Finalizer doesn't run for node in a cycle, so the root doesn't have
+	// any children here, since they would refer back to it.
+	collected := make(chan struct{})
+	func() {
+		root := dom.CreateElement("div")
+		runtime.SetFinalizer(root, func(*html.Node) { close(collected) })
+		it := dom.NewNodeIterator(root, dom.ShowAll, nil)
+		it.NextNode()
+	}()
+
+	// Iterator which is never detached doesn't keep its tree alive
+	if !waitCollected(collected) {
+		t.Errorf("root of NodeIterator is not garbage collected")
+	}
+}
+
+// waitCollected runs the garbage collector until collected is closed
+// by a finalizer, or gives up after a while.
+func waitCollected(collected <-chan struct{}) bool {
+	for i := 0; i < 50; i++ {
+		runtime.GC()
+		select {
+		case <-collected:
+			return true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package dom
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// documentURLs contains the URL of the documents, see SetDocumentURL.
+var documentURLs nodeTable[string]
+
+// SetDocumentURL records the URL where the document is fetched from, which
+// is used as the base for resolving relative URLs. It only works on the
+// tree whose root is a document node, e.g. the result of Parse. The URL
+// is kept outside of the tree, so it's not copied by Clone.
+func SetDocumentURL(doc *html.Node, u *url.URL) {
+	root := rootNode(doc)
+	if root == nil || root.Type != html.DocumentNode {
+		return
+	}
+
+	if u == nil {
+		documentURLs.delete(root)
+		return
+	}
+	documentURLs.set(root, u.String())
+}
+
+// DocumentURL returns the URL of the document where node belongs to,
+// or nil if it's unknown.
+func DocumentURL(node *html.Node) *url.URL {
+	root := rootNode(node)
+	if root == nil || root.Type != html.DocumentNode {
+		return nil
+	}
+
+	rawURL, exist := documentURLs.get(root)
+	if !exist {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// BaseURL returns the base URL of the document where node belongs to, i.e.
+// the href of the first <base> element resolved against the document URL.
+// If there is no <base> element, the document URL is returned. It returns
+// nil if the base URL is unknown.
+func BaseURL(node *html.Node) *url.URL {
+	root := rootNode(node)
+	if root == nil {
+		return nil
+	}
+
+	docURL := DocumentURL(root)
+	for n := range Descendants(root) {
+		if n.Type != html.ElementNode || n.Data != "base" || !HasAttribute(n, "href") {
+			continue
+		}
+
+		href, err := url.Parse(strings.TrimSpace(GetAttribute(n, "href")))
+		switch {
+		case err != nil:
+			return docURL
+		case docURL != nil:
+			return docURL.ResolveReference(href)
+		case href.IsAbs():
+			return href
+		default:
+			return nil
+		}
+	}
+
+	return docURL
+}
+
+// rootNode returns the top most ancestor of node, or node itself if it
+// doesn't have any parent.
+func rootNode(node *html.Node) *html.Node {
+	root := node
+	for parent := range Ancestors(node) {
+		root = parent
+	}
+	return root
+}
@@ -0,0 +1,1413 @@
+package dom
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+var rxXPathNumber = regexp.MustCompile(`^[ \t\r\n]*-?(\d+(\.\d*)?|\.\d+)[ \t\r\n]*$`)
+
+// XPathError is returned when an XPath expression can't be parsed or evaluated.
+type XPathError struct {
+	// Expr is the XPath expression that failed.
+	Expr string
+
+	// Offset is the byte offset in Expr where the error is found.
+	// It's -1 if the error happens while evaluating the expression.
+	Offset int
+
+	// Msg describes the error.
+	Msg string
+}
+
+func (e *XPathError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("xpath %q: %s", e.Expr, e.Msg)
+	}
+	return fmt.Sprintf("xpath %q at offset %d: %s", e.Expr, e.Offset, e.Msg)
+}
+
+// XPath is a compiled XPath 1.0 expression which can be evaluated
+// several times without parsing it again. It's safe for concurrent use.
+type XPath struct {
+	expr string
+	root xpathExpr
+}
+
+// CompileXPath parses an XPath 1.0 expression. It returns an *XPathError
+// if the expression is invalid.
+func CompileXPath(expr string) (*XPath, error) {
+	tokens, err := lexXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &xpathParser{expr: expr, tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &XPath{expr: expr, root: root}, nil
+}
+
+// Evaluate evaluates the expression using node as the context node.
+// Depending on the expression, the result is either a node-set as
+// []*html.Node in document order, a string, a float64 or a bool.
+//
+// Since attributes are not nodes in html.Node, a node-set of attributes
+// (e.g. `//a/@href`) is returned as []XPathAttr instead. A node-set that
+// mixes attributes with other nodes can't be returned, so it's an error.
+func (x *XPath) Evaluate(node *html.Node) (result interface{}, err error) {
+	if node == nil {
+		return nil, &XPathError{Expr: x.expr, Offset: -1, Msg: "context node is nil"}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			msg, ok := r.(xpathRuntimeError)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, &XPathError{Expr: x.expr, Offset: -1, Msg: string(msg)}
+		}
+	}()
+
+	ctx := &xpathContext{
+		node: xnode{node: node, attr: -1},
+		pos:  1,
+		size: 1,
+		doc:  &xpathDocument{root: rootNode(node)},
+	}
+
+	switch value := x.root.eval(ctx).(type) {
+	case []xnode:
+		return xpathNodeSetResult(value), nil
+	default:
+		return value, nil
+	}
+}
+
+// XPathAttr is an attribute selected by an XPath expression.
+type XPathAttr struct {
+	// Owner is the element which has the attribute.
+	Owner *html.Node
+
+	// Attr is a copy of the attribute. Changing it doesn't change the
+	// owner, use SetAttribute on the owner for that.
+	Attr html.Attribute
+}
+
+// String returns the source of the XPath expression.
+func (x *XPath) String() string {
+	return x.expr
+}
+
+// Evaluate evaluates an XPath 1.0 expression using node as the context
+// node. Depending on the expression, the result is either a node-set as
+// []*html.Node in document order, a string, a float64 or a bool. Like
+// (*XPath).Evaluate, a node-set of attributes is returned as []XPathAttr.
+func Evaluate(node *html.Node, expr string) (interface{}, error) {
+	x, err := CompileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return x.Evaluate(node)
+}
+
+// SelectNodes returns the nodes selected by an XPath expression in
+// document order. If the expression is invalid or doesn't return
+// a node-set, it returns nil. Attributes are not nodes in html.Node,
+// so use Evaluate to select them.
+func SelectNodes(node *html.Node, expr string) []*html.Node {
+	result, err := Evaluate(node, expr)
+	if err != nil {
+		return nil
+	}
+
+	nodes, _ := result.([]*html.Node)
+	return nodes
+}
+
+// SelectNode returns the first node selected by an XPath expression.
+// If the expression is invalid or doesn't return a node-set, it
+// returns nil.
+func SelectNode(node *html.Node, expr string) *html.Node {
+	if nodes := SelectNodes(node, expr); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return nil
+}
+
+// xnode is a node in XPath data model. Attributes are not nodes in
+// html.Node, so they are represented by their owner and index.
+type xnode struct {
+	node *html.Node
+	attr int
+}
+
+func (n xnode) isAttr() bool {
+	return n.attr >= 0
+}
+
+// xpathNodeSetResult converts a node-set into the result of Evaluate,
+// which is []XPathAttr if it only contains attributes.
+func xpathNodeSetResult(nodeSet []xnode) interface{} {
+	var (
+		nodes []*html.Node
+		attrs []XPathAttr
+	)
+
+	for _, n := range nodeSet {
+		if n.isAttr() {
+			attrs = append(attrs, XPathAttr{Owner: n.node, Attr: n.node.Attr[n.attr]})
+		} else {
+			nodes = append(nodes, n.node)
+		}
+	}
+
+	switch {
+	case len(attrs) == 0:
+		return nodes
+	case len(nodes) == 0:
+		return attrs
+	default:
+		xpathPanic("node-set contains both attributes and other nodes")
+		return nil
+	}
+}
+
+func (n xnode) stringValue() string {
+	if n.isAttr() {
+		return n.node.Attr[n.attr].Val
+	}
+
+	switch n.node.Type {
+	case html.TextNode, html.CommentNode:
+		return n.node.Data
+	default:
+		return TextContent(n.node)
+	}
+}
+
+func (n xnode) localName() string {
+	if n.isAttr() {
+		return n.node.Attr[n.attr].Key
+	}
+	if n.node.Type == html.ElementNode {
+		return n.node.Data
+	}
+	return ""
+}
+
+func (n xnode) name() string {
+	if n.isAttr() {
+		attr := n.node.Attr[n.attr]
+		if attr.Namespace != "" {
+			return attr.Namespace + ":" + attr.Key
+		}
+		return attr.Key
+	}
+	return n.localName()
+}
+
+func (n xnode) namespaceURI() string {
+	if n.isAttr() || n.node.Type != html.ElementNode {
+		return ""
+	}
+
+	switch n.node.Namespace {
+	case "":
+		return "http://www.w3.org/1999/xhtml"
+	case "svg":
+		return "http://www.w3.org/2000/svg"
+	case "math":
+		return "http://www.w3.org/1998/Math/MathML"
+	default:
+		return n.node.Namespace
+	}
+}
+
+// xpathDocument holds the state shared by a single evaluation.
+type xpathDocument struct {
+	root  *html.Node
+	order map[*html.Node]int
+}
+
+// sortNodes sorts nodes in document order and removes duplicates.
+func (d *xpathDocument) sortNodes(nodes []xnode) []xnode {
+	if len(nodes) < 2 {
+		return nodes
+	}
+
+	if d.order == nil {
+		d.order = map[*html.Node]int{d.root: 0}
+		for i, n := range xpathDescendants(d.root, nil) {
+			d.order[n.node] = i + 1
+		}
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		oi, oj := d.order[nodes[i].node], d.order[nodes[j].node]
+		if oi != oj {
+			return oi < oj
+		}
+		return nodes[i].attr < nodes[j].attr
+	})
+
+	result := nodes[:1]
+	for _, n := range nodes[1:] {
+		if n != result[len(result)-1] {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+type xpathContext struct {
+	node xnode
+	pos  int
+	size int
+	doc  *xpathDocument
+}
+
+type xpathRuntimeError string
+
+func xpathPanic(format string, args ...interface{}) {
+	panic(xpathRuntimeError(fmt.Sprintf(format, args...)))
+}
+
+// xpathDescendants appends descendants of node to out in document order.
+func xpathDescendants(node *html.Node, out []xnode) []xnode {
+	for w := newTreeWalk(node, false); w.next(false); {
+		out = append(out, xnode{node: w.current, attr: -1})
+	}
+	return out
+}
+
+func xpathString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []xnode:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].stringValue()
+	}
+	return ""
+}
+
+func xpathNumber(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		if !rxXPathNumber.MatchString(v) {
+			return math.NaN()
+		}
+		f, _ := strconv.ParseFloat(strings.Trim(v, " \t\r\n"), 64)
+		return f
+	case []xnode:
+		return xpathNumber(xpathString(v))
+	}
+	return math.NaN()
+}
+
+func xpathBoolean(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []xnode:
+		return len(v) > 0
+	}
+	return false
+}
+
+func xpathNodeSet(value interface{}, what string) []xnode {
+	nodes, ok := value.([]xnode)
+	if !ok {
+		xpathPanic("%s requires a node-set", what)
+	}
+	return nodes
+}
+
+// xpathCompare compares two values following the rules in
+// section 3.4 of XPath 1.0 specification.
+func xpathCompare(op string, left, right interface{}) bool {
+	leftNodes, leftIsNodes := left.([]xnode)
+	rightNodes, rightIsNodes := right.([]xnode)
+
+	switch {
+	case leftIsNodes && rightIsNodes:
+		for _, l := range leftNodes {
+			for _, r := range rightNodes {
+				if xpathCompareAtoms(op, l.stringValue(), r.stringValue()) {
+					return true
+				}
+			}
+		}
+		return false
+
+	case leftIsNodes:
+		if b, ok := right.(bool); ok {
+			return xpathCompareAtoms(op, len(leftNodes) > 0, b)
+		}
+		for _, l := range leftNodes {
+			if xpathCompareAtoms(op, l.stringValue(), right) {
+				return true
+			}
+		}
+		return false
+
+	case rightIsNodes:
+		if b, ok := left.(bool); ok {
+			return xpathCompareAtoms(op, b, len(rightNodes) > 0)
+		}
+		for _, r := range rightNodes {
+			if xpathCompareAtoms(op, left, r.stringValue()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return xpathCompareAtoms(op, left, right)
+}
+
+func xpathCompareAtoms(op string, left, right interface{}) bool {
+	if op == "=" || op == "!=" {
+		var equal bool
+		_, leftIsBool := left.(bool)
+		_, rightIsBool := right.(bool)
+		_, leftIsNumber := left.(float64)
+		_, rightIsNumber := right.(float64)
+
+		switch {
+		case leftIsBool || rightIsBool:
+			equal = xpathBoolean(left) == xpathBoolean(right)
+		case leftIsNumber || rightIsNumber:
+			equal = xpathNumber(left) == xpathNumber(right)
+		default:
+			equal = xpathString(left) == xpathString(right)
+		}
+
+		return equal == (op == "=")
+	}
+
+	l, r := xpathNumber(left), xpathNumber(right)
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	if f < 0 && f >= -0.5 {
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+func isXPathSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+type xpathExpr interface {
+	eval(ctx *xpathContext) interface{}
+}
+
+type xpathLiteral string
+
+func (e xpathLiteral) eval(ctx *xpathContext) interface{} {
+	return string(e)
+}
+
+type xpathNumberLiteral float64
+
+func (e xpathNumberLiteral) eval(ctx *xpathContext) interface{} {
+	return float64(e)
+}
+
+type xpathNegate struct {
+	expr xpathExpr
+}
+
+func (e *xpathNegate) eval(ctx *xpathContext) interface{} {
+	return -xpathNumber(e.expr.eval(ctx))
+}
+
+type xpathBinary struct {
+	op    string
+	left  xpathExpr
+	right xpathExpr
+}
+
+func (e *xpathBinary) eval(ctx *xpathContext) interface{} {
+	switch e.op {
+	case "or":
+		return xpathBoolean(e.left.eval(ctx)) || xpathBoolean(e.right.eval(ctx))
+	case "and":
+		return xpathBoolean(e.left.eval(ctx)) && xpathBoolean(e.right.eval(ctx))
+	case "=", "!=", "<", "<=", ">", ">=":
+		return xpathCompare(e.op, e.left.eval(ctx), e.right.eval(ctx))
+	case "|":
+		left := xpathNodeSet(e.left.eval(ctx), "union")
+		right := xpathNodeSet(e.right.eval(ctx), "union")
+		nodes := append(append([]xnode{}, left...), right...)
+		return ctx.doc.sortNodes(nodes)
+	}
+
+	left, right := xpathNumber(e.left.eval(ctx)), xpathNumber(e.right.eval(ctx))
+	switch e.op {
+	case "+":
+		return left + right
+	case "-":
+		return left - right
+	case "*":
+		return left * right
+	case "div":
+		return left / right
+	default:
+		return math.Mod(left, right)
+	}
+}
+
+type xpathFilter struct {
+	expr       xpathExpr
+	predicates []xpathExpr
+}
+
+func (e *xpathFilter) eval(ctx *xpathContext) interface{} {
+	nodes := xpathNodeSet(e.expr.eval(ctx), "predicate")
+	return xpathApplyPredicates(ctx.doc, nodes, e.predicates)
+}
+
+type xpathPath struct {
+	filter   xpathExpr
+	absolute bool
+	steps    []*xpathStep
+}
+
+func (e *xpathPath) eval(ctx *xpathContext) interface{} {
+	var nodes []xnode
+	switch {
+	case e.filter != nil:
+		nodes = xpathNodeSet(e.filter.eval(ctx), "location path")
+	case e.absolute:
+		nodes = []xnode{{node: ctx.doc.root, attr: -1}}
+	default:
+		nodes = []xnode{ctx.node}
+	}
+
+	for _, step := range e.steps {
+		nodes = step.apply(ctx.doc, nodes)
+	}
+
+	return nodes
+}
+
+type xpathStep struct {
+	axis       string
+	test       xpathNodeTest
+	predicates []xpathExpr
+}
+
+func (s *xpathStep) apply(doc *xpathDocument, contexts []xnode) []xnode {
+	var result []xnode
+	for _, context := range contexts {
+		var candidates []xnode
+		for _, n := range xpathAxis(s.axis, context) {
+			if s.test.match(s.axis, n) {
+				candidates = append(candidates, n)
+			}
+		}
+
+		result = append(result, xpathApplyPredicates(doc, candidates, s.predicates)...)
+	}
+
+	if len(contexts) > 1 || xpathReverseAxes[s.axis] {
+		result = doc.sortNodes(result)
+	}
+	return result
+}
+
+func xpathApplyPredicates(doc *xpathDocument, nodes []xnode, predicates []xpathExpr) []xnode {
+	for _, predicate := range predicates {
+		var kept []xnode
+		for i, n := range nodes {
+			ctx := &xpathContext{node: n, pos: i + 1, size: len(nodes), doc: doc}
+			value := predicate.eval(ctx)
+			if f, ok := value.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, n)
+				}
+			} else if xpathBoolean(value) {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes
+}
+
+type xpathNodeTest struct {
+	// nodeType is "node", "text", "comment" or "processing-instruction"
+	// for node type tests, or empty for name tests.
+	nodeType string
+	prefix   string
+	local    string
+}
+
+func (t xpathNodeTest) match(axis string, n xnode) bool {
+	switch t.nodeType {
+	case "node":
+		return n.isAttr() || n.node.Type != html.DoctypeNode
+	case "text":
+		return !n.isAttr() && n.node.Type == html.TextNode
+	case "comment":
+		return !n.isAttr() && n.node.Type == html.CommentNode
+	case "processing-instruction":
+		return false
+	}
+
+	// Name test only matches the principal node type of the axis
+	if axis == "attribute" {
+		if !n.isAttr() {
+			return false
+		}
+		attr := n.node.Attr[n.attr]
+		if t.prefix != "" && attr.Namespace != t.prefix {
+			return false
+		}
+		return t.local == "*" || strings.EqualFold(attr.Key, t.local)
+	}
+
+	if n.isAttr() || n.node.Type != html.ElementNode {
+		return false
+	}
+	if t.prefix != "" && n.node.Namespace != t.prefix {
+		return false
+	}
+	if t.local == "*" {
+		return true
+	}
+	if n.node.Namespace == "" {
+		return strings.EqualFold(n.node.Data, t.local)
+	}
+	return n.node.Data == t.local
+}
+
+var xpathReverseAxes = map[string]bool{
+	"ancestor":          true,
+	"ancestor-or-self":  true,
+	"preceding":         true,
+	"preceding-sibling": true,
+}
+
+// xpathAxis returns the nodes on the axis of n, in axis order.
+func xpathAxis(axis string, n xnode) []xnode {
+	var nodes []xnode
+	switch axis {
+	case "self":
+		nodes = append(nodes, n)
+
+	case "attribute":
+		if !n.isAttr() && n.node.Type == html.ElementNode {
+			for i := range n.node.Attr {
+				nodes = append(nodes, xnode{node: n.node, attr: i})
+			}
+		}
+
+	case "child":
+		if !n.isAttr() {
+			for child := n.node.FirstChild; child != nil; child = child.NextSibling {
+				nodes = append(nodes, xnode{node: child, attr: -1})
+			}
+		}
+
+	case "descendant", "descendant-or-self":
+		if axis == "descendant-or-self" {
+			nodes = append(nodes, n)
+		}
+		if !n.isAttr() {
+			nodes = xpathDescendants(n.node, nodes)
+		}
+
+	case "parent":
+		if n.isAttr() {
+			nodes = append(nodes, xnode{node: n.node, attr: -1})
+		} else if n.node.Parent != nil {
+			nodes = append(nodes, xnode{node: n.node.Parent, attr: -1})
+		}
+
+	case "ancestor", "ancestor-or-self":
+		if axis == "ancestor-or-self" {
+			nodes = append(nodes, n)
+		}
+		parent := n.node.Parent
+		if n.isAttr() {
+			parent = n.node
+		}
+		for ; parent != nil; parent = parent.Parent {
+			nodes = append(nodes, xnode{node: parent, attr: -1})
+		}
+
+	case "following-sibling":
+		if !n.isAttr() {
+			for sibling := n.node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+				nodes = append(nodes, xnode{node: sibling, attr: -1})
+			}
+		}
+
+	case "preceding-sibling":
+		if !n.isAttr() {
+			for sibling := n.node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+				nodes = append(nodes, xnode{node: sibling, attr: -1})
+			}
+		}
+
+	case "following":
+		// Descendants of an attribute's owner come after the attribute
+		if n.isAttr() {
+			nodes = xpathDescendants(n.node, nodes)
+		}
+		for cur := n.node; cur != nil; cur = cur.Parent {
+			for sibling := cur.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+				nodes = append(nodes, xnode{node: sibling, attr: -1})
+				nodes = xpathDescendants(sibling, nodes)
+			}
+		}
+
+	case "preceding":
+		for cur := n.node; cur != nil; cur = cur.Parent {
+			for sibling := cur.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+				subtree := xpathDescendants(sibling, nil)
+				for i := len(subtree) - 1; i >= 0; i-- {
+					nodes = append(nodes, subtree[i])
+				}
+				nodes = append(nodes, xnode{node: sibling, attr: -1})
+			}
+		}
+	}
+
+	// Namespace axis is always empty since html.Node has no namespace nodes
+	return nodes
+}
+
+type xpathFunction struct {
+	minArgs int
+	maxArgs int // -1 means unlimited
+	fn      func(ctx *xpathContext, args []interface{}) interface{}
+}
+
+type xpathCall struct {
+	args []xpathExpr
+	fn   xpathFunction
+}
+
+func (e *xpathCall) eval(ctx *xpathContext) interface{} {
+	args := make([]interface{}, len(e.args))
+	for i, arg := range e.args {
+		args[i] = arg.eval(ctx)
+	}
+	return e.fn.fn(ctx, args)
+}
+
+var xpathFunctions map[string]xpathFunction
+
+func init() {
+	xpathFunctions = map[string]xpathFunction{
+		// Node set functions
+		"last": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(ctx.size)
+		}},
+		"position": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(ctx.pos)
+		}},
+		"count": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(len(xpathNodeSet(args[0], "count()")))
+		}},
+		"id":            {1, 1, xpathFnID},
+		"local-name":    {0, 1, xpathNameFn("local-name()", xnode.localName)},
+		"name":          {0, 1, xpathNameFn("name()", xnode.name)},
+		"namespace-uri": {0, 1, xpathNameFn("namespace-uri()", xnode.namespaceURI)},
+
+		// String functions
+		"string": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return xpathStringArg(ctx, args)
+		}},
+		"concat": {2, -1, func(ctx *xpathContext, args []interface{}) interface{} {
+			var sb strings.Builder
+			for _, arg := range args {
+				sb.WriteString(xpathString(arg))
+			}
+			return sb.String()
+		}},
+		"starts-with": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.HasPrefix(xpathString(args[0]), xpathString(args[1]))
+		}},
+		"contains": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.Contains(xpathString(args[0]), xpathString(args[1]))
+		}},
+		"substring-before": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			s, sep := xpathString(args[0]), xpathString(args[1])
+			if idx := strings.Index(s, sep); idx >= 0 {
+				return s[:idx]
+			}
+			return ""
+		}},
+		"substring-after": {2, 2, func(ctx *xpathContext, args []interface{}) interface{} {
+			s, sep := xpathString(args[0]), xpathString(args[1])
+			if idx := strings.Index(s, sep); idx >= 0 {
+				return s[idx+len(sep):]
+			}
+			return ""
+		}},
+		"substring": {2, 3, xpathFnSubstring},
+		"string-length": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return float64(utf8.RuneCountInString(xpathStringArg(ctx, args)))
+		}},
+		"normalize-space": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return strings.Join(strings.FieldsFunc(xpathStringArg(ctx, args), isXPathSpace), " ")
+		}},
+		"translate": {3, 3, xpathFnTranslate},
+
+		// Boolean functions
+		"boolean": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return xpathBoolean(args[0])
+		}},
+		"not": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return !xpathBoolean(args[0])
+		}},
+		"true": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return true
+		}},
+		"false": {0, 0, func(ctx *xpathContext, args []interface{}) interface{} {
+			return false
+		}},
+		"lang": {1, 1, xpathFnLang},
+
+		// Number functions
+		"number": {0, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			if len(args) == 0 {
+				return xpathNumber(ctx.node.stringValue())
+			}
+			return xpathNumber(args[0])
+		}},
+		"sum": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			var sum float64
+			for _, n := range xpathNodeSet(args[0], "sum()") {
+				sum += xpathNumber(n.stringValue())
+			}
+			return sum
+		}},
+		"floor": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return math.Floor(xpathNumber(args[0]))
+		}},
+		"ceiling": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return math.Ceil(xpathNumber(args[0]))
+		}},
+		"round": {1, 1, func(ctx *xpathContext, args []interface{}) interface{} {
+			return xpathRound(xpathNumber(args[0]))
+		}},
+	}
+}
+
+// xpathStringArg returns the first argument as string, or the string
+// value of the context node if there are no arguments.
+func xpathStringArg(ctx *xpathContext, args []interface{}) string {
+	if len(args) == 0 {
+		return ctx.node.stringValue()
+	}
+	return xpathString(args[0])
+}
+
+func xpathNameFn(name string, fn func(xnode) string) func(*xpathContext, []interface{}) interface{} {
+	return func(ctx *xpathContext, args []interface{}) interface{} {
+		if len(args) == 0 {
+			return fn(ctx.node)
+		}
+
+		nodes := xpathNodeSet(args[0], name)
+		if len(nodes) == 0 {
+			return ""
+		}
+		return fn(nodes[0])
+	}
+}
+
+func xpathFnID(ctx *xpathContext, args []interface{}) interface{} {
+	var ids []string
+	if nodes, ok := args[0].([]xnode); ok {
+		for _, n := range nodes {
+			ids = append(ids, strings.FieldsFunc(n.stringValue(), isXPathSpace)...)
+		}
+	} else {
+		ids = strings.FieldsFunc(xpathString(args[0]), isXPathSpace)
+	}
+
+	wanted := map[string]struct{}{}
+	for _, id := range ids {
+		wanted[id] = struct{}{}
+	}
+
+	var result []xnode
+	for _, n := range xpathDescendants(ctx.doc.root, nil) {
+		if n.node.Type != html.ElementNode {
+			continue
+		}
+		if _, exist := wanted[ID(n.node)]; exist && HasAttribute(n.node, "id") {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+func xpathFnSubstring(ctx *xpathContext, args []interface{}) interface{} {
+	runes := []rune(xpathString(args[0]))
+	start := xpathRound(xpathNumber(args[1]))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + xpathRound(xpathNumber(args[2]))
+	}
+
+	var sb strings.Builder
+	for i, r := range runes {
+		if pos := float64(i + 1); pos >= start && pos < end {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func xpathFnTranslate(ctx *xpathContext, args []interface{}) interface{} {
+	from := []rune(xpathString(args[1]))
+	to := []rune(xpathString(args[2]))
+
+	mapping := map[rune]int{}
+	for i, r := range from {
+		if _, exist := mapping[r]; !exist {
+			mapping[r] = i
+		}
+	}
+
+	var sb strings.Builder
+	for _, r := range xpathString(args[0]) {
+		idx, exist := mapping[r]
+		switch {
+		case !exist:
+			sb.WriteRune(r)
+		case idx < len(to):
+			sb.WriteRune(to[idx])
+		}
+	}
+	return sb.String()
+}
+
+func xpathFnLang(ctx *xpathContext, args []interface{}) interface{} {
+	want := strings.ToLower(xpathString(args[0]))
+	for node := ctx.node.node; node != nil; node = node.Parent {
+		if node.Type != html.ElementNode {
+			continue
+		}
+
+		for _, attr := range node.Attr {
+			if attr.Key != "lang" {
+				continue
+			}
+
+			lang := strings.ToLower(attr.Val)
+			return lang == want || strings.HasPrefix(lang, want+"-")
+		}
+	}
+	return false
+}
+
+const (
+	xtEOF = iota
+	xtName
+	xtStar
+	xtOperator
+	xtLiteral
+	xtNumber
+	xtPunct
+)
+
+type xpathToken struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+func lexXPath(expr string) ([]xpathToken, error) {
+	var tokens []xpathToken
+	i := 0
+
+	for {
+		for i < len(expr) && isXPathSpace(rune(expr[i])) {
+			i++
+		}
+
+		if i >= len(expr) {
+			tokens = append(tokens, xpathToken{kind: xtEOF, pos: i})
+			return tokens, nil
+		}
+
+		// Per section 3.7 of XPath 1.0 specification, `*` and names are
+		// operators when they follow a token that can end an operand.
+		operatorContext := false
+		if n := len(tokens); n > 0 {
+			prev := tokens[n-1]
+			switch {
+			case prev.kind == xtOperator:
+			case prev.kind == xtPunct && strings.Contains(" @ :: ( [ , $ ", " "+prev.text+" "):
+			default:
+				operatorContext = true
+			}
+		}
+
+		start := i
+		c := expr[i]
+		next := byte(0)
+		if i+1 < len(expr) {
+			next = expr[i+1]
+		}
+
+		token := xpathToken{pos: start}
+		switch {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, &XPathError{Expr: expr, Offset: start, Msg: "unterminated string literal"}
+			}
+			token.kind, token.text = xtLiteral, expr[i+1:i+1+end]
+			i += end + 2
+
+		case isDigit(c) || (c == '.' && isDigit(next)):
+			for i < len(expr) && isDigit(expr[i]) {
+				i++
+			}
+			if i < len(expr) && expr[i] == '.' {
+				i++
+				for i < len(expr) && isDigit(expr[i]) {
+					i++
+				}
+			}
+			token.kind, token.text = xtNumber, expr[start:i]
+			token.num, _ = strconv.ParseFloat(token.text, 64)
+
+		case c == '.' && next == '.', c == ':' && next == ':':
+			token.kind, token.text = xtPunct, expr[i:i+2]
+			i += 2
+
+		case strings.IndexByte(".()[]@,$", c) >= 0:
+			token.kind, token.text = xtPunct, expr[i:i+1]
+			i++
+
+		case c == '/' && next == '/', c == '!' && next == '=', c == '<' && next == '=', c == '>' && next == '=':
+			token.kind, token.text = xtOperator, expr[i:i+2]
+			i += 2
+
+		case strings.IndexByte("/|+-=<>", c) >= 0:
+			token.kind, token.text = xtOperator, expr[i:i+1]
+			i++
+
+		case c == '*':
+			token.kind, token.text = xtStar, "*"
+			if operatorContext {
+				token.kind = xtOperator
+			}
+			i++
+
+		default:
+			r, _ := utf8.DecodeRuneInString(expr[i:])
+			if r != '_' && !unicode.IsLetter(r) {
+				return nil, &XPathError{Expr: expr, Offset: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+
+			i = scanXPathName(expr, i)
+			name := expr[start:i]
+			if operatorContext && (name == "and" || name == "or" || name == "mod" || name == "div") {
+				token.kind, token.text = xtOperator, name
+				break
+			}
+
+			// Qualified name, e.g. svg:rect or svg:*
+			if i+1 < len(expr) && expr[i] == ':' && expr[i+1] != ':' {
+				if expr[i+1] == '*' {
+					i += 2
+				} else if r, _ := utf8.DecodeRuneInString(expr[i+1:]); r == '_' || unicode.IsLetter(r) {
+					i = scanXPathName(expr, i+1)
+				}
+			}
+			token.kind, token.text = xtName, expr[start:i]
+		}
+
+		tokens = append(tokens, token)
+	}
+}
+
+func scanXPathName(expr string, i int) int {
+	for i < len(expr) {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		if r != '_' && r != '-' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+var xpathAxes = map[string]struct{}{
+	"ancestor": {}, "ancestor-or-self": {}, "attribute": {}, "child": {},
+	"descendant": {}, "descendant-or-self": {}, "following": {},
+	"following-sibling": {}, "namespace": {}, "parent": {}, "preceding": {},
+	"preceding-sibling": {}, "self": {},
+}
+
+var xpathNodeTypes = map[string]struct{}{
+	"node": {}, "text": {}, "comment": {}, "processing-instruction": {},
+}
+
+type xpathParser struct {
+	expr   string
+	tokens []xpathToken
+	i      int
+}
+
+type xpathSyntaxError struct {
+	pos int
+	msg string
+}
+
+func (p *xpathParser) parse() (expr xpathExpr, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			synErr, ok := r.(xpathSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			expr, err = nil, &XPathError{Expr: p.expr, Offset: synErr.pos, Msg: synErr.msg}
+		}
+	}()
+
+	expr = p.parseOr()
+	if token := p.peek(0); token.kind != xtEOF {
+		p.fail(token, "unexpected %q", token.text)
+	}
+	return expr, nil
+}
+
+func (p *xpathParser) fail(token xpathToken, format string, args ...interface{}) {
+	panic(xpathSyntaxError{pos: token.pos, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *xpathParser) peek(offset int) xpathToken {
+	if p.i+offset < len(p.tokens) {
+		return p.tokens[p.i+offset]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *xpathParser) next() xpathToken {
+	token := p.peek(0)
+	if token.kind != xtEOF {
+		p.i++
+	}
+	return token
+}
+
+func (p *xpathParser) is(kind int, text string) bool {
+	token := p.peek(0)
+	return token.kind == kind && token.text == text
+}
+
+func (p *xpathParser) expect(kind int, text string) {
+	if !p.is(kind, text) {
+		token := p.peek(0)
+		if token.kind == xtEOF {
+			p.fail(token, "expected %q, found end of expression", text)
+		}
+		p.fail(token, "expected %q, found %q", text, token.text)
+	}
+	p.next()
+}
+
+func (p *xpathParser) parseBinary(ops []string, operand func() xpathExpr) xpathExpr {
+	expr := operand()
+	for {
+		token := p.peek(0)
+		if token.kind != xtOperator || !stringInSlice(token.text, ops) {
+			return expr
+		}
+		p.next()
+		expr = &xpathBinary{op: token.text, left: expr, right: operand()}
+	}
+}
+
+func (p *xpathParser) parseOr() xpathExpr {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *xpathParser) parseAnd() xpathExpr {
+	return p.parseBinary([]string{"and"}, p.parseEquality)
+}
+
+func (p *xpathParser) parseEquality() xpathExpr {
+	return p.parseBinary([]string{"=", "!="}, p.parseRelational)
+}
+
+func (p *xpathParser) parseRelational() xpathExpr {
+	return p.parseBinary([]string{"<", "<=", ">", ">="}, p.parseAdditive)
+}
+
+func (p *xpathParser) parseAdditive() xpathExpr {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *xpathParser) parseMultiplicative() xpathExpr {
+	return p.parseBinary([]string{"*", "div", "mod"}, p.parseUnary)
+}
+
+func (p *xpathParser) parseUnary() xpathExpr {
+	if p.is(xtOperator, "-") {
+		p.next()
+		return &xpathNegate{expr: p.parseUnary()}
+	}
+	return p.parseBinary([]string{"|"}, p.parsePath)
+}
+
+func (p *xpathParser) parsePath() xpathExpr {
+	token := p.peek(0)
+	isFilter := token.kind == xtLiteral || token.kind == xtNumber ||
+		(token.kind == xtPunct && (token.text == "(" || token.text == "$"))
+	if token.kind == xtName && p.peek(1).kind == xtPunct && p.peek(1).text == "(" {
+		_, isNodeType := xpathNodeTypes[token.text]
+		isFilter = !isNodeType
+	}
+
+	if !isFilter {
+		return p.parseLocationPath()
+	}
+
+	filter := p.parseFilter()
+	if !p.is(xtOperator, "/") && !p.is(xtOperator, "//") {
+		return filter
+	}
+
+	return &xpathPath{filter: filter, steps: p.parseMoreSteps(nil)}
+}
+
+func (p *xpathParser) parseFilter() xpathExpr {
+	var expr xpathExpr
+	token := p.next()
+
+	switch {
+	case token.kind == xtLiteral:
+		expr = xpathLiteral(token.text)
+	case token.kind == xtNumber:
+		expr = xpathNumberLiteral(token.num)
+	case token.kind == xtPunct && token.text == "$":
+		p.fail(token, "variable references are not supported")
+	case token.kind == xtPunct && token.text == "(":
+		expr = p.parseOr()
+		p.expect(xtPunct, ")")
+	default:
+		expr = p.parseCall(token)
+	}
+
+	predicates := p.parsePredicates()
+	if len(predicates) == 0 {
+		return expr
+	}
+	return &xpathFilter{expr: expr, predicates: predicates}
+}
+
+func (p *xpathParser) parseCall(name xpathToken) xpathExpr {
+	fn, exist := xpathFunctions[name.text]
+	if !exist {
+		p.fail(name, "unknown function %s()", name.text)
+	}
+
+	p.expect(xtPunct, "(")
+	var args []xpathExpr
+	if !p.is(xtPunct, ")") {
+		args = append(args, p.parseOr())
+		for p.is(xtPunct, ",") {
+			p.next()
+			args = append(args, p.parseOr())
+		}
+	}
+	p.expect(xtPunct, ")")
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		p.fail(name, "wrong number of arguments for %s()", name.text)
+	}
+
+	return &xpathCall{args: args, fn: fn}
+}
+
+func (p *xpathParser) parseLocationPath() xpathExpr {
+	switch {
+	case p.is(xtOperator, "/"):
+		p.next()
+		path := &xpathPath{absolute: true}
+		if p.canStartStep() {
+			path.steps = p.parseRelativePath(nil)
+		}
+		return path
+
+	case p.is(xtOperator, "//"):
+		p.next()
+		descendants := &xpathStep{axis: "descendant-or-self", test: xpathNodeTest{nodeType: "node"}}
+		return &xpathPath{absolute: true, steps: p.parseRelativePath([]*xpathStep{descendants})}
+
+	default:
+		return &xpathPath{steps: p.parseRelativePath(nil)}
+	}
+}
+
+// parseRelativePath parses a relative location path and appends
+// its steps to steps.
+func (p *xpathParser) parseRelativePath(steps []*xpathStep) []*xpathStep {
+	steps = append(steps, p.parseStep())
+	return p.parseMoreSteps(steps)
+}
+
+// parseMoreSteps parses the following `/step` or `//step` of
+// a location path and appends them to steps.
+func (p *xpathParser) parseMoreSteps(steps []*xpathStep) []*xpathStep {
+	for {
+		switch {
+		case p.is(xtOperator, "/"):
+			p.next()
+		case p.is(xtOperator, "//"):
+			p.next()
+			steps = append(steps, &xpathStep{axis: "descendant-or-self", test: xpathNodeTest{nodeType: "node"}})
+		default:
+			return steps
+		}
+		steps = append(steps, p.parseStep())
+	}
+}
+
+func (p *xpathParser) canStartStep() bool {
+	token := p.peek(0)
+	switch token.kind {
+	case xtName, xtStar:
+		return true
+	case xtPunct:
+		return token.text == "." || token.text == ".." || token.text == "@"
+	}
+	return false
+}
+
+func (p *xpathParser) parseStep() *xpathStep {
+	switch {
+	case p.is(xtPunct, "."):
+		p.next()
+		return &xpathStep{axis: "self", test: xpathNodeTest{nodeType: "node"}}
+	case p.is(xtPunct, ".."):
+		p.next()
+		return &xpathStep{axis: "parent", test: xpathNodeTest{nodeType: "node"}}
+	}
+
+	step := &xpathStep{axis: "child"}
+	if p.is(xtPunct, "@") {
+		p.next()
+		step.axis = "attribute"
+	} else if token := p.peek(0); token.kind == xtName && p.peek(1).kind == xtPunct && p.peek(1).text == "::" {
+		if _, exist := xpathAxes[token.text]; !exist {
+			p.fail(token, "unknown axis %s", token.text)
+		}
+		step.axis = token.text
+		p.next()
+		p.next()
+	}
+
+	token := p.next()
+	switch token.kind {
+	case xtStar:
+		step.test = xpathNodeTest{local: "*"}
+
+	case xtName:
+		if _, isNodeType := xpathNodeTypes[token.text]; isNodeType && p.is(xtPunct, "(") {
+			p.next()
+			if token.text == "processing-instruction" && p.peek(0).kind == xtLiteral {
+				p.next()
+			}
+			p.expect(xtPunct, ")")
+			step.test = xpathNodeTest{nodeType: token.text}
+			break
+		}
+
+		step.test = xpathNodeTest{local: token.text}
+		if idx := strings.IndexByte(token.text, ':'); idx >= 0 {
+			step.test = xpathNodeTest{prefix: token.text[:idx], local: token.text[idx+1:]}
+		}
+
+	case xtEOF:
+		p.fail(token, "expected location step, found end of expression")
+
+	default:
+		p.fail(token, "expected location step, found %q", token.text)
+	}
+
+	step.predicates = p.parsePredicates()
+	return step
+}
+
+func (p *xpathParser) parsePredicates() []xpathExpr {
+	var predicates []xpathExpr
+	for p.is(xtPunct, "[") {
+		p.next()
+		predicates = append(predicates, p.parseOr())
+		p.expect(xtPunct, "]")
+	}
+	return predicates
+}
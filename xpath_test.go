@@ -0,0 +1,263 @@
+package dom_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/go-shiori/dom"
+	"golang.org/x/net/html"
+)
+
+const xpathTestHTML = `<html lang="en-US"><head><title>Test</title></head><body>
+<div id="main" class="content">
+	<h1>Heading</h1>
+	<p class="intro">First <b>bold</b> paragraph</p>
+	<p>Second   paragraph
+	with newline</p>
+	<!-- a comment -->
+	<ul>
+		<li>One</li>
+		<li>Two</li>
+		<li>Three</li>
+	</ul>
+	<a href="/one" rel="next">Link one</a>
+	<a href="/two">Link two</a>
+</div>
+<div id="footer"><p>Footer</p></div>
+</body></html>`
+
+func parseXPathTestHTML(t *testing.T) *html.Node {
+	doc, err := html.Parse(strings.NewReader(xpathTestHTML))
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	return doc
+}
+
+func TestSelectNodes(t *testing.T) {
+	doc := parseXPathTestHTML(t)
+
+	tests := map[string][]string{
+		"//p":                            {"p", "p", "p"},
+		"/html/body/div":                 {"div", "div"},
+		"//div[@id='main']/h1":           {"h1"},
+		"//li[2]":                        {"li"},
+		"//li[last()]/text()":            {"Three"},
+		"//li[position() > 1]":           {"li", "li"},
+		"//p[contains(., 'bold')]/b":     {"b"},
+		"//a/@href":                      {"/one", "/two"},
+		"//a[@rel]/@*":                   {"/one", "next"},
+		"//b/ancestor::div":              {"div"},
+		"//b/ancestor::*[1]":             {"p"},
+		"//h1/following-sibling::p[1]":   {"p"},
+		"//ul/preceding-sibling::p[1]":   {"p"},
+		"//ul/preceding::p":              {"p", "p"},
+		"//h1/following::p":              {"p", "p", "p"},
+		"//div[@id='main']/comment()":    {" a comment "},
+		"//p[b] | //h1":                  {"h1", "p"},
+		"(//p)[last()]":                  {"p"},
+		"(//li)[2]/..":                   {"ul"},
+		"//li[. = 'Two']":                {"li"},
+		"//*[@class='intro']/self::p":    {"p"},
+		"id('footer')/p":                 {"p"},
+		"//div[count(p) = 2]":            {"div"},
+		"//p[normalize-space() = '']":    {},
+		"//title[lang('en')]":            {"title"},
+		"//body/descendant::li[3]":       {"li"},
+		"//div/descendant-or-self::div":  {"div", "div"},
+		"//ul/ancestor-or-self::*[3]":    {"body"},
+		"//p[starts-with(@class, 'in')]": {"p"},
+	}
+
+	for expr, want := range tests {
+		t.Run(expr, func(t *testing.T) {
+			nodes, err := dom.Evaluate(doc, expr)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			var got []string
+			switch nodes := nodes.(type) {
+			case []*html.Node:
+				for _, node := range nodes {
+					got = append(got, node.Data)
+				}
+			case []dom.XPathAttr:
+				for _, attr := range nodes {
+					got = append(got, attr.Attr.Val)
+				}
+			default:
+				t.Fatalf("Evaluate() = %T, want node-set", nodes)
+			}
+
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("Evaluate() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSelectNode(t *testing.T) {
+	doc := parseXPathTestHTML(t)
+
+	if node := dom.SelectNode(doc, "//li"); dom.TextContent(node) != "One" {
+		t.Errorf("SelectNode() = %q, want %q", dom.TextContent(node), "One")
+	}
+
+	if node := dom.SelectNode(doc, "//table"); node != nil {
+		t.Errorf("SelectNode() = %v, want nil", dom.OuterHTML(node))
+	}
+
+	if nodes := dom.SelectNodes(doc, "count(//li)"); nodes != nil {
+		t.Errorf("SelectNodes() = %v, want nil for non node-set", len(nodes))
+	}
+
+	// Attributes are only returned by Evaluate
+	if nodes := dom.SelectNodes(doc, "//a/@href"); nodes != nil {
+		t.Errorf("SelectNodes() = %v, want nil for attributes", len(nodes))
+	}
+
+	result, err := dom.Evaluate(doc, "//a[@rel]/@rel")
+	if attrs, ok := result.([]dom.XPathAttr); err != nil || !ok || len(attrs) != 1 ||
+		attrs[0].Owner != dom.SelectNode(doc, "//a[@rel]") || attrs[0].Attr.Key != "rel" {
+		t.Errorf("Evaluate() = %v, %v, want rel attribute of <a>", result, err)
+	}
+
+	if _, err := dom.Evaluate(doc, "//a | //a/@href"); err == nil {
+		t.Errorf("Evaluate() error = nil, want error for mixed node-set")
+	}
+
+	// Relative path uses the specified node as context
+	main := dom.GetElementByID(doc, "main")
+	if nodes := dom.SelectNodes(main, "p"); len(nodes) != 2 {
+		t.Errorf("SelectNodes() = %v, want 2", len(nodes))
+	}
+
+	if nodes := dom.SelectNodes(main, "//p"); len(nodes) != 3 {
+		t.Errorf("SelectNodes() = %v, want 3", len(nodes))
+	}
+
+	// Consistent with QuerySelectorAll
+	selected := dom.SelectNodes(doc, "//div[@id='main']//li")
+	queried := dom.QuerySelectorAll(doc, "div#main li")
+	if len(selected) != len(queried) {
+		t.Fatalf("SelectNodes() = %v, QuerySelectorAll() = %v", len(selected), len(queried))
+	}
+	for i := range selected {
+		if selected[i] != queried[i] {
+			t.Errorf("SelectNodes()[%d] differs from QuerySelectorAll()[%d]", i, i)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	doc := parseXPathTestHTML(t)
+
+	tests := map[string]interface{}{
+		"count(//li)":                         float64(3),
+		"count(//a[@href])":                   float64(2),
+		"string(//h1)":                        "Heading",
+		"string(//a/@href)":                   "/one",
+		"normalize-space(//p[2])":             "Second paragraph with newline",
+		"concat('a', 'b', 1)":                 "ab1",
+		"substring('12345', 2, 3)":            "234",
+		"substring('12345', 1.5, 2.6)":        "234",
+		"substring('12345', 0 div 0, 3)":      "",
+		"substring('12345', -42, 1 div 0)":    "12345",
+		"substring-before('1999/04/01', '/')": "1999",
+		"substring-after('1999/04/01', '/')":  "04/01",
+		"translate('bar', 'abc', 'ABC')":      "BAr",
+		"translate('--aaa--', 'abc-', 'ABC')": "AAA",
+		"string-length('héllo')":              float64(5),
+		"name(//a/@href)":                     "href",
+		"local-name(//h1)":                    "h1",
+		"1 + 2 * 3":                           float64(7),
+		"7 mod 3":                             float64(1),
+		"-7 mod 3":                            float64(-1),
+		"10 div 4":                            2.5,
+		"-(1 - 3)":                            float64(2),
+		"round(2.5)":                          float64(3),
+		"round(-2.5)":                         float64(-2),
+		"floor(-1.5)":                         float64(-2),
+		"ceiling(1.2)":                        float64(2),
+		"number('  12.5 ')":                   12.5,
+		"string(1 div 0)":                     "Infinity",
+		"string(0 div 0)":                     "NaN",
+		"string(3.0)":                         "3",
+		"string(true())":                      "true",
+		"sum(//nonexistent)":                  float64(0),
+		"//li = 'Two'":                        true,
+		"//li != 'Two'":                       true,
+		"//li = 'Four'":                       false,
+		"//li > 1":                            false,
+		"count(//li) >= 3 and count(//p) < 4": true,
+		"false() or not(//table)":             true,
+		"boolean(//li)":                       true,
+		"//li = true()":                       true,
+		"1 = '1'":                             true,
+		"position()":                          float64(1),
+		"div":                                 []string{},
+		"//div/@id = //div[p]/@id":            true,
+		"count(//p[@class='intro']/following-sibling::*)": float64(4),
+	}
+
+	for expr, want := range tests {
+		t.Run(expr, func(t *testing.T) {
+			got, err := dom.Evaluate(doc, expr)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			switch w := want.(type) {
+			case []string:
+				if nodes := got.([]*html.Node); len(nodes) != len(w) {
+					t.Errorf("Evaluate() = %v nodes, want %v", len(nodes), len(w))
+				}
+			case float64:
+				if g, ok := got.(float64); !ok || (g != w && !(math.IsNaN(g) && math.IsNaN(w))) {
+					t.Errorf("Evaluate() = %v, want %v", got, want)
+				}
+			default:
+				if got != want {
+					t.Errorf("Evaluate() = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestXPathError(t *testing.T) {
+	doc := parseXPathTestHTML(t)
+
+	tests := map[string]int{
+		"//p[":                -1,
+		"//p[@class='a'":      14,
+		"foo()":               0,
+		"child::p/unknown::a": 9,
+		"'unterminated":       0,
+		"$var":                0,
+		"//p +":               5,
+		"count('a')":          -1,
+		"substring('a')":      0,
+		"1 | 2":               -1,
+	}
+
+	for expr, offset := range tests {
+		t.Run(expr, func(t *testing.T) {
+			_, err := dom.Evaluate(doc, expr)
+			xpathErr, ok := err.(*dom.XPathError)
+			if !ok {
+				t.Fatalf("Evaluate() error = %v, want *XPathError", err)
+			}
+
+			if offset >= 0 && xpathErr.Offset != offset {
+				t.Errorf("XPathError.Offset = %v, want %v", xpathErr.Offset, offset)
+			}
+
+			if xpathErr.Expr != expr {
+				t.Errorf("XPathError.Expr = %q, want %q", xpathErr.Expr, expr)
+			}
+		})
+	}
+}